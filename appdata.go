@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+// appDataKey is the key of the publish state in a note's application data.
+const appDataKey = "yinxiangblog"
+
+// PublishState is what the blog records about a post in its note: where it
+// is published, since when, and a hash of the title and content it was
+// published with.
+type PublishState struct {
+	URL       string `json:"url,omitempty"`
+	Slug      string `json:"slug"`
+	Published int64  `json:"published"`
+	Hash      string `json:"hash"`
+}
+
+// ReadState returns the publish state recorded in a note, or nil.
+func (c *Client) ReadState(guid string) (*PublishState, error) {
+	s := c.sessionFor(guid)
+	var value string
+	err := c.callOn(s, "GetNoteApplicationDataEntry", func(store *notestore.NoteStoreClient) (err error) {
+		value, err = store.GetNoteApplicationDataEntry(s.token, types.GUID(guid), appDataKey)
+		if e, ok := err.(*edam.EDAMNotFoundException); ok && e.GetIdentifier() == "NoteAttributes.applicationData.key" {
+			value, err = "", nil
+		}
+		return err
+	})
+	if err != nil || value == "" {
+		return nil, err
+	}
+	var state PublishState
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		log.Printf("note %s: ignoring its publish state: %v", guid, err)
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// WriteState records the publish state of a post in its note. Once the
+// service denies it, because the token may only read notes, the client
+// stays read-only and skips further writes.
+func (c *Client) WriteState(guid string, state *PublishState) error {
+	c.mu.Lock()
+	readOnly := c.readOnly
+	c.mu.Unlock()
+	if readOnly {
+		return nil
+	}
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s := c.sessionFor(guid)
+	denied := false
+	err = c.callOn(s, "SetNoteApplicationDataEntry", func(store *notestore.NoteStoreClient) error {
+		_, err := store.SetNoteApplicationDataEntry(s.token, types.GUID(guid), appDataKey, string(buf))
+		if e, ok := err.(*edam.EDAMUserException); ok && e.ErrorCode == edam.EDAMErrorCode_PERMISSION_DENIED {
+			denied, err = true, nil
+		}
+		return err
+	})
+	if denied {
+		c.mu.Lock()
+		if !c.readOnly {
+			log.Println("the token may not modify notes, publish state is not recorded")
+		}
+		c.readOnly = true
+		c.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildWriteBack(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	cfg.WriteBack = true
+	cfg.BaseURL = "https://blog.example.com"
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	var state PublishState
+	if err := json.Unmarshal([]byte(f.store.appData["note-1"][appDataKey]), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.URL != "https://blog.example.com/hello%20world.html" || state.Slug != "hello world" || state.Hash == "" {
+		t.Errorf("recorded %+v", state)
+	}
+
+	rebuild := func() {
+		os.Remove(s.marker)
+		s = rebuild(f.client(cfg), cfg)
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.store.putNote("nb-blog", "note-1", "hello again", helloENML)
+	rebuild()
+	if p := readMeta(cfg.ReleaseDir)["note-1"]; p.Slug != "hello world" || p.Title != "hello again" {
+		t.Errorf("got %+v, want the slug kept after the title changed", p)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "hello again.html")); err == nil {
+		t.Error("page written under the new title")
+	}
+	if n := f.store.count("GetResourceByHash note-2"); n != 1 {
+		t.Errorf("image of note-2 fetched %d times, want it not rendered again", n)
+	}
+
+	// the notes only changed by recording their state
+	rebuild()
+	if _, err := os.Stat(s.marker); err == nil {
+		t.Error("site marked changed although no post changed")
+	}
+}
+
+func TestBuildWriteBackReadOnly(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.readOnly = true
+	cfg := testConfig()
+	cfg.WriteBack = true
+	cfg.Concurrency = 1
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("SetNoteApplicationDataEntry note-1") + f.store.count("SetNoteApplicationDataEntry note-2"); n != 1 {
+		t.Errorf("tried to record the state %d times, want once", n)
+	}
+	if len(readMeta(cfg.ReleaseDir)) != 2 {
+		t.Error("posts not published")
+	}
+}
+
+func TestBuildWithoutWriteBack(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("GetNoteApplicationDataEntry note-1") + f.store.count("GetNoteApplicationDataEntry note-2"); n != 0 {
+		t.Errorf("publish state read %d times, want none without write back", n)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrjones/oauth"
+)
+
+const authTimeout = 10 * time.Minute
+
+func oauthProvider(host string) oauth.ServiceProvider {
+	return oauth.ServiceProvider{
+		RequestTokenUrl:   host + "/oauth",
+		AuthorizeTokenUrl: host + "/OAuth.action",
+		AccessTokenUrl:    host + "/oauth",
+	}
+}
+
+// runAuth lets the user authorize the blog in a browser and stores the
+// access token and its expiry in the config file.
+func runAuth(cfg *Config, out io.Writer) error {
+	if cfg.ConsumerKey == "" || cfg.ConsumerSecret == "" {
+		return errors.New("auth needs consumer_key and consumer_secret in the config")
+	}
+	host, err := cfg.ServiceHost()
+	if err != nil {
+		return err
+	}
+	hc, err := newHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+	consumer := oauth.NewCustomHttpClientConsumer(cfg.ConsumerKey, cfg.ConsumerSecret, oauthProvider(host), hc)
+	listen := cfg.AuthListen
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	token, err := authorize(consumer, ln, out, authTimeout)
+	if err != nil {
+		return err
+	}
+	cfg.EvernoteToken = token.Token
+	cfg.EvernoteTokenExpires, _ = strconv.ParseInt(token.AdditionalData["edam_expires"], 10, 64)
+	if err := writeConfig(configFile, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "token saved to", configFile)
+	return nil
+}
+
+// authorize runs the OAuth flow: it prints the URL the user has to open,
+// waits for the service to redirect the browser to a callback served on ln
+// and exchanges the verifier for an access token.
+func authorize(consumer *oauth.Consumer, ln net.Listener, out io.Writer, timeout time.Duration) (*oauth.AccessToken, error) {
+	callback := "http://" + ln.Addr().String() + "/callback"
+	rtoken, url, err := consumer.GetRequestTokenAndUrl(callback)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(out, "Open this URL in your browser to authorize yinxiangblog:")
+	fmt.Fprintln(out, url)
+
+	verifier := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/callback" || q.Get("oauth_token") != rtoken.Token {
+			http.NotFound(w, r)
+			return
+		}
+		v := q.Get("oauth_verifier")
+		if v == "" {
+			fmt.Fprintln(w, "Authorization was declined.")
+		} else {
+			fmt.Fprintln(w, "yinxiangblog is authorized, you can close this window.")
+		}
+		select {
+		case verifier <- v:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	select {
+	case v := <-verifier:
+		if v == "" {
+			return nil, errors.New("authorization declined")
+		}
+		return consumer.AuthorizeToken(rtoken, v)
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for the authorization")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrjones/oauth"
+)
+
+const fakeAccessToken = "S=s1:U=1f:E=16c9a5e3c00:C=1:P=185:A=yinxiangblog:V=2:H=0"
+
+// fakeOAuth is a local stand-in for the service's OAuth endpoints. The
+// callback URL of every request token is sent on callbacks.
+type fakeOAuth struct {
+	*httptest.Server
+	callbacks chan string
+}
+
+func newFakeOAuth() *fakeOAuth {
+	f := &fakeOAuth{callbacks: make(chan string, 1)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := oauthParams(r.Header.Get("Authorization"))
+		switch {
+		case r.URL.Path != "/oauth":
+			http.NotFound(w, r)
+		case params["oauth_callback"] != "":
+			f.callbacks <- params["oauth_callback"]
+			w.Write([]byte("oauth_token=req-token&oauth_token_secret=req-secret&oauth_callback_confirmed=true"))
+		case params["oauth_token"] == "req-token" && params["oauth_verifier"] == "the-verifier":
+			w.Write([]byte(url.Values{
+				"oauth_token":        {fakeAccessToken},
+				"oauth_token_secret": {""},
+				"edam_userId":        {"31"},
+				"edam_expires":       {"1565999000000"},
+			}.Encode()))
+		default:
+			http.Error(w, "bad request", http.StatusUnauthorized)
+		}
+	}))
+	return f
+}
+
+// oauthParams parses an `OAuth k="v",...` Authorization header.
+func oauthParams(header string) map[string]string {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(header, "OAuth "), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		v, _ := url.QueryUnescape(strings.Trim(parts[1], `"`))
+		params[parts[0]] = v
+	}
+	return params
+}
+
+func TestAuthorize(t *testing.T) {
+	f := newFakeOAuth()
+	defer f.Close()
+	consumer := oauth.NewCustomHttpClientConsumer("key", "secret", oauthProvider(f.URL), f.Client())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	type result struct {
+		token *oauth.AccessToken
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		token, err := authorize(consumer, ln, &out, time.Minute)
+		done <- result{token, err}
+	}()
+
+	// play the browser: the service redirects to the callback once the user
+	// agreed.
+	callback := <-f.callbacks
+	resp, err := http.Get(callback + "?oauth_token=req-token&oauth_verifier=the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.token.Token != fakeAccessToken || res.token.AdditionalData["edam_expires"] != "1565999000000" {
+		t.Errorf("got token %+v", res.token)
+	}
+	if !strings.Contains(out.String(), f.URL+"/OAuth.action?oauth_token=req-token") {
+		t.Errorf("authorize URL not printed: %q", out.String())
+	}
+}
+
+func TestAuthorizeDeclined(t *testing.T) {
+	f := newFakeOAuth()
+	defer f.Close()
+	consumer := oauth.NewCustomHttpClientConsumer("key", "secret", oauthProvider(f.URL), f.Client())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := authorize(consumer, ln, ioutil.Discard, time.Minute)
+		done <- err
+	}()
+	callback := <-f.callbacks
+	resp, err := http.Get(callback + "?oauth_token=req-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := <-done; err == nil {
+		t.Error("declined authorization returned a token")
+	}
+}
+
+func TestRunAuth(t *testing.T) {
+	f := newFakeOAuth()
+	defer f.Close()
+	dir, err := ioutil.TempDir("", "yinxiangblog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(name string) { configFile = name }(configFile)
+	configFile = filepath.Join(dir, "config.json")
+
+	cfg := &Config{ConsumerKey: "key", ConsumerSecret: "secret", Environment: f.URL, EvernoteGUIDs: []string{"nb-blog"}}
+	done := make(chan error, 1)
+	go func() { done <- runAuth(cfg, ioutil.Discard) }()
+	callback := <-f.callbacks
+	resp, err := http.Get(callback + "?oauth_token=req-token&oauth_verifier=the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got, err := readFromFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EvernoteToken != fakeAccessToken || got.EvernoteTokenExpires != 1565999000000 || got.EvernoteGUIDs[0] != "nb-blog" {
+		t.Errorf("saved config %+v", got)
+	}
+}
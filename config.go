@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var configFile = "config.json"
+
+type Config struct {
+	EvernoteToken   string   `json:"evernote_token"`
+	EvernoteGUID    string   `json:"evernote_guid"`
+	EvernoteGUIDs   []string `json:"evernote_guids"`
+	EvernoteStack   string   `json:"evernote_stack"`
+	EnexPath        string   `json:"enex_path"`
+	ReleaseDir      string   `json:"release_dir"`
+	ReleaseProject  string   `json:"release_project"`
+	ReleaseUserName string   `json:"release_username"`
+	ReleaseBranch   string   `json:"release_branch"`
+	// EvernoteTokenExpires is the expiry of the token in milliseconds, for
+	// tokens which don't carry it themselves.
+	EvernoteTokenExpires int64 `json:"evernote_token_expires,omitempty"`
+	// Retries is how many times a NoteStore call is attempted before the
+	// build fails, 5 when unset.
+	Retries int `json:"retries"`
+	// Concurrency limits the number of notes and resources downloaded at
+	// once, 4 when unset.
+	Concurrency int `json:"concurrency"`
+	// HTTPTimeout is the timeout of API requests in seconds, 60 when unset.
+	HTTPTimeout int `json:"http_timeout"`
+	// Proxy is the URL of the proxy for API requests. The environment's
+	// HTTPS_PROXY is used when unset.
+	Proxy string `json:"proxy"`
+	// LinkedNotebooks are the share names of notebooks of other accounts,
+	// shared with the token's account, to publish as well.
+	LinkedNotebooks []string `json:"linked_notebooks"`
+	// PublishTag limits the blog to the notes carrying this tag. Every note
+	// of the blog notebooks is published when it is unset.
+	PublishTag string `json:"publish_tag"`
+	// HiddenTags keeps the notes carrying any of them off the blog, "draft"
+	// and "private" when unset. An empty list hides nothing.
+	HiddenTags []string `json:"hidden_tags"`
+	// MarkdownTag marks the notes written in Markdown, "markdown" when
+	// unset. Notes whose first line is "<!-- markdown -->" are as well.
+	MarkdownTag string `json:"markdown_tag"`
+	// HighlightStyle is the chroma style, such as "monokai", highlight.css
+	// colours code in, "github" when unset.
+	HighlightStyle string `json:"highlight_style"`
+	// SavedSearch is the name of a saved search whose query selects the
+	// posts within the blog notebooks. Builds with a saved search always
+	// list every note, as its query can't be applied to sync chunks.
+	SavedSearch string `json:"saved_search"`
+	// History is how many past versions of a post its history page shows.
+	// No history pages are written when it is 0.
+	History int `json:"history"`
+	// Related is how many related posts a page lists at its bottom. No
+	// related posts are listed when it is 0.
+	Related int `json:"related"`
+	// Redirects leaves a page redirecting to the new address of a post at
+	// the old one when its slug changes.
+	Redirects bool `json:"redirects"`
+	// InlineImages embeds images in the pages as data URIs instead of
+	// writing them to the images directory, for pages that stand alone.
+	InlineImages bool `json:"inline_images"`
+	// ImageWidths are the widths in pixels of the downscaled variants of
+	// JPEG and PNG images browsers choose from, 480, 960 and 1440 when
+	// unset. An empty list publishes images at their size only.
+	ImageWidths []int `json:"image_widths"`
+	// KeepMetadata publishes JPEG and PNG images with the metadata they
+	// carry, such as the GPS position of a photo, which is stripped by
+	// default.
+	KeepMetadata bool `json:"keep_metadata"`
+	// PublicAttributes are the note attributes posts show and meta.json
+	// records: "author" and "location". None are by default.
+	PublicAttributes []string `json:"public_attributes"`
+	// WriteBack records the permalink, publish time and content hash of
+	// every published post in the application data of its note. It needs a
+	// token which may modify notes; the build carries on without recording
+	// anything when it may not.
+	WriteBack bool `json:"write_back"`
+	// BaseURL is the address the blog is served at, used for permalinks.
+	// GitHub Pages of the release project are assumed when unset.
+	BaseURL string `json:"base_url"`
+	// Environment is the service to talk to: "yinxiang" (the default),
+	// "evernote", "sandbox" or the base URL of any other host, such as a
+	// mirror or a local test server.
+	Environment string `json:"environment"`
+	// ConsumerKey and ConsumerSecret are the API key the auth command uses
+	// to get a token.
+	ConsumerKey    string `json:"consumer_key,omitempty"`
+	ConsumerSecret string `json:"consumer_secret,omitempty"`
+	// AuthListen is the address of the auth command's callback server, a
+	// free local port when unset.
+	AuthListen string `json:"auth_listen,omitempty"`
+}
+
+// NotebookGUIDs returns every configured notebook guid, evernote_guid first.
+func (cfg *Config) NotebookGUIDs() []string {
+	var guids []string
+	if cfg.EvernoteGUID != "" {
+		guids = append(guids, cfg.EvernoteGUID)
+	}
+	return append(guids, cfg.EvernoteGUIDs...)
+}
+
+var defaultHiddenTags = []string{"draft", "private"}
+
+const defaultMarkdownTag = "markdown"
+
+const defaultHighlightStyle = "github"
+
+var defaultImageWidths = []int{480, 960, 1440}
+
+// imageWidths returns the widths of the variants of images, narrowest
+// first.
+func (cfg *Config) imageWidths() []int {
+	if cfg.ImageWidths == nil {
+		return defaultImageWidths
+	}
+	widths := append([]int(nil), cfg.ImageWidths...)
+	sort.Ints(widths)
+	return widths
+}
+
+// hiddenTags returns the tags which keep a note unpublished.
+func (cfg *Config) hiddenTags() []string {
+	if cfg.HiddenTags == nil {
+		return defaultHiddenTags
+	}
+	return cfg.HiddenTags
+}
+
+// Publishable tells whether a note with the given tag names goes on the
+// blog. Tag names are compared ignoring case, as Evernote does.
+func (cfg *Config) Publishable(tags []string) bool {
+	published := cfg.PublishTag == ""
+	for _, tag := range tags {
+		for _, hidden := range cfg.hiddenTags() {
+			if strings.EqualFold(tag, hidden) {
+				return false
+			}
+		}
+		if strings.EqualFold(tag, cfg.PublishTag) {
+			published = true
+		}
+	}
+	return published
+}
+
+// markdown tells whether a note with the given tag names is written in
+// Markdown.
+func (cfg *Config) markdown(tags []string) bool {
+	marker := cfg.MarkdownTag
+	if marker == "" {
+		marker = defaultMarkdownTag
+	}
+	for _, tag := range tags {
+		if strings.EqualFold(tag, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteURL returns the address of the blog, ending in a slash, or "" when
+// it isn't known.
+func (cfg *Config) SiteURL() string {
+	switch {
+	case cfg.BaseURL != "":
+		return strings.TrimSuffix(cfg.BaseURL, "/") + "/"
+	case cfg.ReleaseUserName != "" && cfg.ReleaseProject != "":
+		return fmt.Sprintf("https://%s.github.io/%s/", cfg.ReleaseUserName, cfg.ReleaseProject)
+	}
+	return ""
+}
+
+var environments = map[string]string{
+	"":         "https://app.yinxiang.com",
+	"yinxiang": "https://app.yinxiang.com",
+	"evernote": "https://www.evernote.com",
+	"sandbox":  "https://sandbox.evernote.com",
+}
+
+// ServiceHost returns the base URL of the configured environment.
+func (cfg *Config) ServiceHost() (string, error) {
+	if host, ok := environments[cfg.Environment]; ok {
+		return host, nil
+	}
+	u, err := url.Parse(cfg.Environment)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("unknown environment %q", cfg.Environment)
+	}
+	return strings.TrimSuffix(cfg.Environment, "/"), nil
+}
+
+// TokenExpiry returns when the token expires, or the zero time when that
+// isn't known.
+func (cfg *Config) TokenExpiry() time.Time {
+	if expiry, ok := tokenExpiry(cfg.EvernoteToken); ok {
+		return expiry
+	}
+	if cfg.EvernoteTokenExpires > 0 {
+		return time.Unix(0, cfg.EvernoteTokenExpires*int64(time.Millisecond))
+	}
+	return time.Time{}
+}
+
+func ReadConfig() (*Config, error) {
+	if ci := os.Getenv("CIRCLECI"); ci != "" {
+		return readFromCircleCIEnv(), nil
+	}
+	if _, err := os.Stat(configFile); err == nil {
+		return readFromFile(configFile)
+	}
+	return nil, errors.New("config not found")
+}
+
+func readFromCircleCIEnv() *Config {
+	var cfg Config
+	cfg.EvernoteToken = os.Getenv("TOKEN")
+	cfg.EvernoteGUIDs = splitList(os.Getenv("GUID"))
+	cfg.EvernoteStack = os.Getenv("STACK")
+	cfg.LinkedNotebooks = splitList(os.Getenv("LINKED"))
+	cfg.EnexPath = os.Getenv("ENEX")
+	cfg.Environment = os.Getenv("ENVIRONMENT")
+	cfg.PublishTag = os.Getenv("PUBLISH_TAG")
+	if hidden, ok := os.LookupEnv("HIDDEN_TAGS"); ok {
+		cfg.HiddenTags = splitList(hidden)
+		if cfg.HiddenTags == nil {
+			cfg.HiddenTags = []string{}
+		}
+	}
+	cfg.MarkdownTag = os.Getenv("MARKDOWN_TAG")
+	cfg.SavedSearch = os.Getenv("SAVED_SEARCH")
+	cfg.WriteBack = os.Getenv("WRITE_BACK") != ""
+	cfg.BaseURL = os.Getenv("BASE_URL")
+	cfg.ReleaseProject = os.Getenv("CIRCLE_PROJECT_REPONAME")
+	cfg.ReleaseUserName = os.Getenv("CIRCLE_PROJECT_USERNAME")
+	cfg.ReleaseBranch = os.Getenv("RELEASE_BRANCH")
+	cfg.ReleaseDir = "public"
+	return &cfg
+}
+
+func readFromFile(name string) (*Config, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ReleaseDir == "" {
+		cfg.ReleaseDir = "public"
+	}
+	return &cfg, nil
+}
+
+// writeConfig saves cfg, e.g. after the auth command got a new token.
+func writeConfig(name string, cfg *Config) error {
+	buf, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(buf, '\n'), 0600)
+}
+
+// splitList splits a comma separated env value, dropping empty items.
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
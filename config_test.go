@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestServiceHost(t *testing.T) {
+	for env, want := range map[string]string{
+		"":                       "https://app.yinxiang.com",
+		"evernote":               "https://www.evernote.com",
+		"sandbox":                "https://sandbox.evernote.com",
+		"http://127.0.0.1:8080/": "http://127.0.0.1:8080",
+	} {
+		cfg := &Config{Environment: env}
+		if got, err := cfg.ServiceHost(); err != nil || got != want {
+			t.Errorf("ServiceHost(%q) = %q, %v, want %q", env, got, err, want)
+		}
+	}
+	for _, env := range []string{"china", "ftp://mirror", "http://"} {
+		cfg := &Config{Environment: env}
+		if _, err := cfg.ServiceHost(); err == nil {
+			t.Errorf("ServiceHost(%q) accepted", env)
+		}
+	}
+}
+
+func TestPublishable(t *testing.T) {
+	for _, c := range []struct {
+		cfg  Config
+		tags []string
+		want bool
+	}{
+		{Config{}, nil, true},
+		{Config{}, []string{"Draft"}, false},
+		{Config{}, []string{"go", "private"}, false},
+		{Config{HiddenTags: []string{}}, []string{"draft"}, true},
+		{Config{PublishTag: "publish"}, nil, false},
+		{Config{PublishTag: "publish"}, []string{"go", "Publish"}, true},
+		{Config{PublishTag: "publish"}, []string{"publish", "draft"}, false},
+	} {
+		if got := c.cfg.Publishable(c.tags); got != c.want {
+			t.Errorf("%+v.Publishable(%q) = %v, want %v", c.cfg, c.tags, got, c.want)
+		}
+	}
+}
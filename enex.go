@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+const enexTime = "20060102T150405Z"
+
+type enexExport struct {
+	Notes []enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Attrs     enexAttributes `xml:"note-attributes"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexAttributes struct {
+	SubjectDate  string   `xml:"subject-date"`
+	ReminderTime string   `xml:"reminder-time"`
+	Author       string   `xml:"author"`
+	Latitude     *float64 `xml:"latitude"`
+	Longitude    *float64 `xml:"longitude"`
+	PlaceName    string   `xml:"place-name"`
+}
+
+type enexResource struct {
+	Data        string `xml:"data"`
+	Mime        string `xml:"mime"`
+	Recognition string `xml:"recognition"`
+	FileName    string `xml:"resource-attributes>file-name"`
+	Width       int    `xml:"width"`
+	Height      int    `xml:"height"`
+}
+
+// readEnex loads an Evernote export. name is either an .enex file or a
+// directory of them; each file is treated as one notebook named after it.
+func readEnex(name string) (*memorySource, error) {
+	files := []string{name}
+	if fi, err := os.Stat(name); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		files, err = filepath.Glob(filepath.Join(name, "*.enex"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	src := newMemorySource()
+	for _, file := range files {
+		if err := src.addEnex(file); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func (m *memorySource) addEnex(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var export enexExport
+	if err := xml.NewDecoder(f).Decode(&export); err != nil {
+		return err
+	}
+	notebook := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	for _, note := range export.Notes {
+		var resources []*Resource
+		for _, r := range note.Resources {
+			data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(r.Data), ""))
+			if err != nil {
+				return err
+			}
+			text, err := utils.Recognition([]byte(r.Recognition))
+			if err != nil {
+				return err
+			}
+			sum := md5.Sum(data)
+			resources = append(resources, &Resource{
+				Hash:     hex.EncodeToString(sum[:]),
+				Mime:     r.Mime,
+				Data:     data,
+				Text:     text,
+				FileName: r.FileName,
+				Width:    r.Width,
+				Height:   r.Height,
+			})
+		}
+		updated := note.Updated
+		if updated == "" {
+			updated = note.Created
+		}
+		// exports carry no guids, so derive a stable one from the notebook,
+		// title and creation time.
+		sum := md5.Sum([]byte(notebook + "\x00" + note.Title + "\x00" + note.Created))
+		p := Post{
+			GUID:         hex.EncodeToString(sum[:]),
+			Title:        note.Title,
+			Update:       enexTimestamp(updated),
+			NotebookGUID: notebook,
+			Notebook:     notebook,
+			Tags:         note.Tags,
+			PublishAt:    note.Attrs.publishTime(),
+			Author:       note.Attrs.Author,
+			Location:     note.Attrs.location(),
+			Content:      note.Content,
+		}
+		m.Add(p, resources...)
+	}
+	return nil
+}
+
+// location is the export's counterpart of noteLocation.
+func (a enexAttributes) location() *Location {
+	if (a.Latitude == nil || a.Longitude == nil) && a.PlaceName == "" {
+		return nil
+	}
+	l := &Location{Place: a.PlaceName}
+	if a.Latitude != nil && a.Longitude != nil {
+		l.Latitude, l.Longitude = *a.Latitude, *a.Longitude
+	}
+	return l
+}
+
+// publishTime is the export's counterpart of publishTime.
+func (a enexAttributes) publishTime() int64 {
+	if a.SubjectDate != "" {
+		return enexTimestamp(a.SubjectDate)
+	}
+	return enexTimestamp(a.ReminderTime)
+}
+
+// enexTimestamp converts an export time to milliseconds, like
+// types.Timestamp.
+func enexTimestamp(s string) int64 {
+	t, err := time.Parse(enexTime, s)
+	if err != nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
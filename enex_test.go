@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReadEnex(t *testing.T) {
+	src, err := readEnex("testdata/blog.enex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err := src.ListPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	var pic Post
+	for _, p := range posts {
+		if p.Notebook != "blog" {
+			t.Errorf("post %q in notebook %q, want blog", p.Title, p.Notebook)
+		}
+		if p.Title == "test pic" {
+			pic = p
+		}
+	}
+	if pic.Update != 1533888000000 {
+		t.Errorf("got update %d, want 1533888000000", pic.Update)
+	}
+	res, err := src.FetchResource(pic.GUID, "5eb63bbbe01eeed093cb22bb8f5acdc3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != "hello world" || res.Mime != "image/png" {
+		t.Errorf("got resource %q %s", res.Data, res.Mime)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// Client is the PostSource backed by the Evernote/Yinxiang API.
+type Client struct {
+	cfg       *Config
+	token     string
+	notebooks map[string]string
+	// linked holds the linked blog notebooks by notebook guid.
+	linked map[string]*linkedNotebook
+	// tags maps the guid of every tag of the account to its name.
+	tags map[string]string
+	// query is the query of the configured saved search.
+	query   string
+	session *session
+	// sleep waits between retries; tests replace it.
+	sleep func(time.Duration)
+
+	mu sync.Mutex
+	// pausedUntil holds back all calls after the rate limit was reached.
+	pausedUntil time.Time
+	// located is the notebook guid of every listed post.
+	located map[string]string
+	// readOnly is set once the token turned out not to be allowed to
+	// modify notes.
+	readOnly bool
+	// noRelated is set once the service turned out not to find related
+	// notes.
+	noRelated bool
+	// noHistory is set once the service refused to list note versions.
+	noHistory bool
+}
+
+// newClient returns a Client for the environment, token and notebooks of
+// cfg.
+func newClient(cfg *Config) (*Client, error) {
+	host, err := cfg.ServiceHost()
+	if err != nil {
+		return nil, err
+	}
+	hc, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cc := &Client{
+		cfg:     cfg,
+		token:   cfg.EvernoteToken,
+		session: newSession(cfg.EvernoteToken, cfg.TokenExpiry(), host+"/edam/user", hc),
+		sleep:   time.Sleep,
+	}
+	return cc, nil
+}
+
+// resolveNotebooks fills c.notebooks with the guid and name of every blog
+// notebook: the configured guids, the configured linked notebooks and the
+// notebooks of the configured stack, whether owned or linked.
+func (c *Client) resolveNotebooks() error {
+	if c.notebooks != nil {
+		return nil
+	}
+	var list []*types.Notebook
+	err := c.call("ListNotebooks", func(store *notestore.NoteStoreClient) (err error) {
+		list, err = store.ListNotebooks(c.token)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	want := make(map[string]bool)
+	for _, guid := range c.cfg.NotebookGUIDs() {
+		want[guid] = true
+	}
+	notebooks := make(map[string]string)
+	for _, nb := range list {
+		guid := string(nb.GetGUID())
+		stack := c.cfg.EvernoteStack
+		if want[guid] || (stack != "" && nb.GetStack() == stack) {
+			notebooks[guid] = nb.GetName()
+			delete(want, guid)
+		}
+	}
+	for guid := range want {
+		return fmt.Errorf("notebook %s not found", guid)
+	}
+	if err := c.resolveLinked(notebooks); err != nil {
+		return err
+	}
+	if len(notebooks) == 0 {
+		return errors.New("no notebooks configured")
+	}
+	c.notebooks = notebooks
+	return nil
+}
+
+// resolveTags fills c.tags, including the tags of linked notebooks, and
+// checks that the publish tag exists.
+func (c *Client) resolveTags() error {
+	if c.tags != nil {
+		return nil
+	}
+	var list []*types.Tag
+	err := c.call("ListTags", func(store *notestore.NoteStoreClient) (err error) {
+		list, err = store.ListTags(c.token)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, guid := range c.linkedGUIDs() {
+		s := c.linked[guid].session
+		var shared []*types.Tag
+		err := c.callOn(s, "ListTagsByNotebook", func(store *notestore.NoteStoreClient) (err error) {
+			shared, err = store.ListTagsByNotebook(s.token, types.GUID(guid))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		list = append(list, shared...)
+	}
+	tags := make(map[string]string, len(list))
+	for _, tag := range list {
+		tags[string(tag.GetGUID())] = tag.GetName()
+	}
+	c.tags = tags
+	if c.cfg.PublishTag != "" && len(c.tagGUIDs(c.cfg.PublishTag)) == 0 {
+		return fmt.Errorf("tag %s not found", c.cfg.PublishTag)
+	}
+	return nil
+}
+
+// tagGUIDs returns the sorted guids of the tags with the given names.
+func (c *Client) tagGUIDs(names ...string) []string {
+	var guids []string
+	for guid, tag := range c.tags {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(tag, name) {
+				guids = append(guids, guid)
+				break
+			}
+		}
+	}
+	sort.Strings(guids)
+	return guids
+}
+
+// resolveSearch looks up the query of the configured saved search.
+func (c *Client) resolveSearch() error {
+	if c.cfg.SavedSearch == "" || c.query != "" {
+		return nil
+	}
+	var list []*types.SavedSearch
+	err := c.call("ListSearches", func(store *notestore.NoteStoreClient) (err error) {
+		list, err = store.ListSearches(c.token)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, search := range list {
+		if strings.EqualFold(search.GetName(), c.cfg.SavedSearch) {
+			c.query = search.GetQuery()
+			return nil
+		}
+	}
+	return fmt.Errorf("saved search %s not found", c.cfg.SavedSearch)
+}
+
+// resolve looks up everything that defines the posts: notebooks, tags and
+// the saved search.
+func (c *Client) resolve() error {
+	if err := c.resolveNotebooks(); err != nil {
+		return err
+	}
+	if err := c.resolveTags(); err != nil {
+		return err
+	}
+	return c.resolveSearch()
+}
+
+func (c *Client) notebookGUIDs() []string {
+	guids := make([]string, 0, len(c.notebooks))
+	for guid := range c.notebooks {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids
+}
+
+func (c *Client) newPost(guid, title string, update int64, notebook string, tagGUIDs []string, attrs *types.NoteAttributes) Post {
+	var tags []string
+	for _, tag := range tagGUIDs {
+		if name, ok := c.tags[tag]; ok {
+			tags = append(tags, name)
+		}
+	}
+	return Post{
+		GUID:         guid,
+		Title:        title,
+		Update:       update,
+		NotebookGUID: notebook,
+		Notebook:     c.notebooks[notebook],
+		Tags:         tags,
+		PublishAt:    publishTime(attrs),
+		Author:       noteAuthor(attrs),
+		Location:     noteLocation(attrs),
+	}
+}
+
+const pageSize = 100
+
+func (c *Client) ListPosts() (map[string]Post, error) {
+	if err := c.resolve(); err != nil {
+		return nil, err
+	}
+	return c.listNotebooks(c.notebookGUIDs())
+}
+
+// listNotebooks lists the posts of the given blog notebooks.
+func (c *Client) listNotebooks(guids []string) (map[string]Post, error) {
+	t := true
+	resSpec := notestore.NotesMetadataResultSpec{
+		IncludeTitle:        &t,
+		IncludeUpdated:      &t,
+		IncludeNotebookGuid: &t,
+		IncludeTagGuids:     &t,
+		IncludeAttributes:   &t,
+	}
+	res := make(map[string]Post)
+	for _, guid := range guids {
+		s := c.sessionOf(guid)
+		bloguuid := types.GUID(guid)
+		filter := notestore.NoteFilter{
+			NotebookGuid: &bloguuid,
+		}
+		if c.query != "" {
+			filter.Words = &c.query
+		}
+		for offset := int32(0); ; {
+			var ll *notestore.NotesMetadataList
+			err := c.callOn(s, "FindNotesMetadata", func(store *notestore.NoteStoreClient) (err error) {
+				ll, err = store.FindNotesMetadata(s.token, &filter, offset, pageSize, &resSpec)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			notes := ll.GetNotes()
+			for _, note := range notes {
+				p := c.newPost(string(note.GUID), note.GetTitle(), int64(note.GetUpdated()), guid, note.TagGuids, note.Attributes)
+				if c.cfg.Publishable(p.Tags) {
+					res[p.GUID] = p
+				}
+			}
+			offset += int32(len(notes))
+			if len(notes) == 0 || offset >= ll.GetTotalNotes() {
+				break
+			}
+		}
+	}
+	c.locate(res)
+	return res, nil
+}
+
+func (c *Client) FetchContent(guid string) (string, error) {
+	s := c.sessionFor(guid)
+	noteguid := types.GUID(guid)
+	var r *types.Note
+	err := c.callOn(s, "GetNote", func(store *notestore.NoteStoreClient) (err error) {
+		r, err = store.GetNote(s.token, noteguid, true, false, false, false)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return r.GetContent(), nil
+}
+
+// FetchPost returns a post, without its content, as it would be listed.
+func (c *Client) FetchPost(guid string) (Post, error) {
+	s := c.sessionFor(guid)
+	var r *types.Note
+	err := c.callOn(s, "GetNote", func(store *notestore.NoteStoreClient) (err error) {
+		r, err = store.GetNote(s.token, types.GUID(guid), false, false, false, false)
+		return err
+	})
+	if err != nil {
+		return Post{}, err
+	}
+	return c.newPost(guid, r.GetTitle(), int64(r.GetUpdated()), r.GetNotebookGuid(), r.TagGuids, r.Attributes), nil
+}
+
+func (c *Client) FetchResource(guid, hashHex string) (*Resource, error) {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return nil, err
+	}
+	s := c.sessionFor(guid)
+	noteguid := types.GUID(guid)
+	var res *types.Resource
+	err = c.callOn(s, "GetResourceByHash", func(store *notestore.NoteStoreClient) (err error) {
+		res, err = store.GetResourceByHash(s.token, noteguid, hash, true, true, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := res.GetData()
+	if data == nil {
+		return nil, fmt.Errorf("resource %s of note %s has no data", hashHex, guid)
+	}
+	var text string
+	if reco := res.GetRecognition(); reco != nil {
+		if text, err = utils.Recognition(reco.Body); err != nil {
+			return nil, fmt.Errorf("resource %s of note %s: %v", hashHex, guid, err)
+		}
+	}
+	var name string
+	if attrs := res.GetAttributes(); attrs != nil {
+		name = attrs.GetFileName()
+	}
+	return &Resource{
+		Hash:     hashHex,
+		Mime:     res.GetMime(),
+		Data:     data.Body,
+		Text:     text,
+		FileName: name,
+		Width:    int(res.GetWidth()),
+		Height:   int(res.GetHeight()),
+	}, nil
+}
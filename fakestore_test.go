@@ -0,0 +1,705 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/dreampuf/evernote-sdk-golang/userstore"
+)
+
+// fakeEvernote is a local stand-in for the Evernote service. It serves a
+// UserStore at /edam/user and a NoteStore at /edam/note over the Thrift
+// binary protocol, backed by fixture notebooks, plus the NoteStore of
+// another account at /edam/shared, whose notebooks can be linked. Calls it
+// does not implement panic, which fails the request.
+type fakeEvernote struct {
+	*httptest.Server
+	users  *fakeUserStore
+	store  *fakeNoteStore
+	shared *fakeNoteStore
+
+	mu sync.Mutex
+	// unavailable is the number of coming requests answered with a 502.
+	unavailable int
+}
+
+func newFakeEvernote() *fakeEvernote {
+	f := &fakeEvernote{store: newFakeNoteStore(), shared: newFakeNoteStore()}
+	proto := thrift.NewTBinaryProtocolFactoryDefault()
+	mux := http.NewServeMux()
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		down := f.unavailable > 0
+		if down {
+			f.unavailable--
+		}
+		f.mu.Unlock()
+		if down {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	f.users = &fakeUserStore{url: f.URL + "/edam/note"}
+	mux.HandleFunc("/edam/user", thrift.NewThriftHandlerFunc(userstore.NewUserStoreProcessor(f.users), proto, proto))
+	mux.HandleFunc("/edam/note", thrift.NewThriftHandlerFunc(notestore.NewNoteStoreProcessor(f.store), proto, proto))
+	mux.HandleFunc("/edam/shared", thrift.NewThriftHandlerFunc(notestore.NewNoteStoreProcessor(f.shared), proto, proto))
+	return f
+}
+
+// goDown answers the next n requests with a 502.
+func (f *fakeEvernote) goDown(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unavailable = n
+}
+
+// share links a notebook of the shared account to the account under the
+// given share name.
+func (f *fakeEvernote) share(notebook, name, stack string) {
+	key := "key-" + notebook
+	url := f.URL + "/edam/shared"
+	ln := &types.LinkedNotebook{
+		GUID:         guidPtr("linked-" + notebook),
+		ShareName:    &name,
+		ShareKey:     &key,
+		NoteStoreUrl: &url,
+	}
+	if stack != "" {
+		ln.Stack = &stack
+	}
+	f.store.mu.Lock()
+	f.store.linked = append(f.store.linked, ln)
+	f.store.mu.Unlock()
+	f.shared.mu.Lock()
+	f.shared.shares[key] = notebook
+	f.shared.mu.Unlock()
+}
+
+// client returns a Client for cfg talking to the fake service.
+func (f *fakeEvernote) client(cfg *Config) *Client {
+	cfg.Environment = f.URL
+	c, err := newClient(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+type fakeUserStore struct {
+	userstore.UserStore
+	url   string
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *fakeUserStore) GetNoteStoreUrl(authenticationToken string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.url, nil
+}
+
+// fakeCurrentTime is the server time reported by the fake, fixed so that
+// build output is reproducible.
+const fakeCurrentTime = 1533000000000
+
+type fakeExpunged struct {
+	usn  int32
+	guid string
+}
+
+type fakeNoteStore struct {
+	notestore.NoteStore
+	mu        sync.Mutex
+	usn       int32
+	notebooks []*types.Notebook
+	tags      []*types.Tag
+	searches  []*types.SavedSearch
+	linked    []*types.LinkedNotebook
+	// shares maps the share keys of the store's shared notebooks to their
+	// guids.
+	shares map[string]string
+	notes  map[string]*types.Note
+	// versions are the replaced versions of every note, oldest first.
+	versions map[string][]*types.Note
+	// appData holds the application data entries of every note.
+	appData map[string]map[string]string
+	// readOnly makes the store deny modifications, like for a read-only
+	// token.
+	readOnly bool
+	// related are the related notes of every note. FindRelated is
+	// unsupported, like on some services, while it is nil.
+	related  map[string][]string
+	expunged []fakeExpunged
+	// calls counts the calls per method and guid, e.g. "GetNote note-1".
+	calls map[string]int
+	// failures are returned, in order, by the next calls of a method.
+	failures map[string][]error
+}
+
+func newFakeNoteStore() *fakeNoteStore {
+	return &fakeNoteStore{
+		notes:    make(map[string]*types.Note),
+		shares:   make(map[string]string),
+		versions: make(map[string][]*types.Note),
+		appData:  make(map[string]map[string]string),
+		calls:    make(map[string]int),
+		failures: make(map[string][]error),
+	}
+}
+
+// fail makes the next calls of method return errs.
+func (s *fakeNoteStore) fail(method string, errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], errs...)
+}
+
+// record counts a call and returns the failure queued for its method.
+func (s *fakeNoteStore) record(method, guid string) error {
+	call := method
+	if guid != "" {
+		call += " " + guid
+	}
+	s.calls[call]++
+	if errs := s.failures[method]; len(errs) > 0 {
+		s.failures[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (s *fakeNoteStore) nextUSN() int32 {
+	s.usn++
+	return s.usn
+}
+
+func (s *fakeNoteStore) count(call string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[call]
+}
+
+func (s *fakeNoteStore) addNotebook(guid, name, stack string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nb := &types.Notebook{
+		GUID: guidPtr(guid),
+		Name: &name,
+	}
+	if stack != "" {
+		nb.Stack = &stack
+	}
+	s.notebooks = append(s.notebooks, nb)
+}
+
+func (s *fakeNoteStore) addTag(guid, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = append(s.tags, &types.Tag{GUID: guidPtr(guid), Name: &name})
+}
+
+func (s *fakeNoteStore) addSearch(name, query string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches = append(s.searches, &types.SavedSearch{GUID: guidPtr("search-" + name), Name: &name, Query: &query})
+}
+
+// tagNote replaces the tags of a note.
+func (s *fakeNoteStore) tagNote(guid string, tags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	note := s.notes[guid]
+	usn := s.nextUSN()
+	updated := types.Timestamp(fakeCurrentTime + int64(usn)*1000)
+	note.TagGuids = tags
+	note.Updated, note.UpdateSequenceNum = &updated, &usn
+}
+
+// putNote creates or updates a note. Its updated time is derived from the
+// new USN so that output stays reproducible.
+func (s *fakeNoteStore) putNote(notebook, guid, title, content string, resources ...*types.Resource) *types.Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usn := s.nextUSN()
+	updated := types.Timestamp(fakeCurrentTime + int64(usn)*1000)
+	active := true
+	note := &types.Note{
+		GUID:              guidPtr(guid),
+		Title:             &title,
+		Content:           &content,
+		Updated:           &updated,
+		Active:            &active,
+		UpdateSequenceNum: &usn,
+		NotebookGuid:      &notebook,
+		Resources:         resources,
+	}
+	for _, r := range resources {
+		r.NoteGuid = guidPtr(guid)
+		r.UpdateSequenceNum = &usn
+	}
+	if old, ok := s.notes[guid]; ok {
+		s.versions[guid] = append(s.versions[guid], old)
+	}
+	s.notes[guid] = note
+	return note
+}
+
+// trashNote moves a note to the trash.
+func (s *fakeNoteStore) trashNote(guid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	note := s.notes[guid]
+	usn := s.nextUSN()
+	active := false
+	deleted := types.Timestamp(fakeCurrentTime)
+	note.Active, note.Deleted, note.UpdateSequenceNum = &active, &deleted, &usn
+}
+
+func (s *fakeNoteStore) expungeNote(guid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.notes, guid)
+	s.expunged = append(s.expunged, fakeExpunged{s.nextUSN(), guid})
+}
+
+func fakeResource(mime string, body []byte) *types.Resource {
+	hash := md5.Sum(body)
+	size := int32(len(body))
+	return &types.Resource{
+		GUID: guidPtr("res-" + hex.EncodeToString(hash[:4])),
+		Mime: &mime,
+		Data: &types.Data{BodyHash: hash[:], Size: &size, Body: body},
+	}
+}
+
+func guidPtr(guid string) *types.GUID {
+	g := types.GUID(guid)
+	return &g
+}
+
+func (s *fakeNoteStore) note(guid types.GUID) (*types.Note, error) {
+	note, ok := s.notes[string(guid)]
+	if !ok {
+		identifier := "Note.guid"
+		key := string(guid)
+		return nil, &edam.EDAMNotFoundException{Identifier: &identifier, Key: &key}
+	}
+	return note, nil
+}
+
+// sortedNotes returns the notes in guid order.
+func (s *fakeNoteStore) sortedNotes() []*types.Note {
+	notes := make([]*types.Note, 0, len(s.notes))
+	for _, note := range s.notes {
+		notes = append(notes, note)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].GetGUID() < notes[j].GetGUID()
+	})
+	return notes
+}
+
+func (s *fakeNoteStore) GetSyncState(authenticationToken string) (*notestore.SyncState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetSyncState", ""); err != nil {
+		return nil, err
+	}
+	return &notestore.SyncState{CurrentTime: fakeCurrentTime, UpdateCount: s.usn}, nil
+}
+
+func (s *fakeNoteStore) GetFilteredSyncChunk(authenticationToken string, afterUSN int32, maxEntries int32, filter *notestore.SyncChunkFilter) (*notestore.SyncChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetFilteredSyncChunk", ""); err != nil {
+		return nil, err
+	}
+	return s.syncChunk(afterUSN, maxEntries, filter, ""), nil
+}
+
+// syncChunk returns the changes after afterUSN, of the given notebook only
+// unless it is empty.
+func (s *fakeNoteStore) syncChunk(afterUSN int32, maxEntries int32, filter *notestore.SyncChunkFilter, notebook string) *notestore.SyncChunk {
+	type entry struct {
+		usn   int32
+		apply func(*notestore.SyncChunk)
+	}
+	var entries []entry
+	for _, note := range s.notes {
+		note := note
+		if note.GetUpdateSequenceNum() <= afterUSN || (notebook != "" && note.GetNotebookGuid() != notebook) {
+			continue
+		}
+		entries = append(entries, entry{note.GetUpdateSequenceNum(), func(chunk *notestore.SyncChunk) {
+			if filter.GetIncludeNotes() {
+				meta := *note
+				meta.Content, meta.Resources = nil, nil
+				chunk.Notes = append(chunk.Notes, &meta)
+			}
+			if filter.GetIncludeResources() {
+				for _, r := range note.Resources {
+					res := *r
+					res.Data = &types.Data{BodyHash: r.Data.BodyHash, Size: r.Data.Size}
+					chunk.Resources = append(chunk.Resources, &res)
+				}
+			}
+		}})
+	}
+	for _, e := range s.expunged {
+		e := e
+		if e.usn <= afterUSN || !filter.GetIncludeExpunged() {
+			continue
+		}
+		entries = append(entries, entry{e.usn, func(chunk *notestore.SyncChunk) {
+			chunk.ExpungedNotes = append(chunk.ExpungedNotes, e.guid)
+		}})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].usn < entries[j].usn })
+	if len(entries) > int(maxEntries) {
+		entries = entries[:maxEntries]
+	}
+	chunk := &notestore.SyncChunk{CurrentTime: fakeCurrentTime, UpdateCount: s.usn}
+	for _, e := range entries {
+		e.apply(chunk)
+		high := e.usn
+		chunk.ChunkHighUSN = &high
+	}
+	return chunk
+}
+
+func (s *fakeNoteStore) ListLinkedNotebooks(authenticationToken string) ([]*types.LinkedNotebook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListLinkedNotebooks", ""); err != nil {
+		return nil, err
+	}
+	return s.linked, nil
+}
+
+// sharedNotebook returns the guid of the notebook a shared notebook token
+// was issued for.
+func (s *fakeNoteStore) sharedNotebook(authenticationToken string) (string, error) {
+	notebook, ok := s.shares[strings.TrimPrefix(authenticationToken, "shared:")]
+	if !ok {
+		code := edam.EDAMErrorCode_INVALID_AUTH
+		return "", &edam.EDAMUserException{ErrorCode: code}
+	}
+	return notebook, nil
+}
+
+func (s *fakeNoteStore) AuthenticateToSharedNotebook(shareKey string, authenticationToken string) (*userstore.AuthenticationResult_, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("AuthenticateToSharedNotebook", ""); err != nil {
+		return nil, err
+	}
+	if _, ok := s.shares[shareKey]; !ok {
+		identifier := "SharedNotebook.shareKey"
+		return nil, &edam.EDAMNotFoundException{Identifier: &identifier, Key: &shareKey}
+	}
+	return &userstore.AuthenticationResult_{CurrentTime: fakeCurrentTime, AuthenticationToken: "shared:" + shareKey}, nil
+}
+
+// authorize checks that a store sharing notebooks is read with the token
+// of the share a note's notebook belongs to.
+func (s *fakeNoteStore) authorize(authenticationToken string, note *types.Note) error {
+	if len(s.shares) == 0 {
+		return nil
+	}
+	notebook, err := s.sharedNotebook(authenticationToken)
+	if err == nil && notebook != note.GetNotebookGuid() {
+		code := edam.EDAMErrorCode_PERMISSION_DENIED
+		err = &edam.EDAMUserException{ErrorCode: code}
+	}
+	return err
+}
+
+func (s *fakeNoteStore) GetSharedNotebookByAuth(authenticationToken string) (*types.SharedNotebook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetSharedNotebookByAuth", ""); err != nil {
+		return nil, err
+	}
+	notebook, err := s.sharedNotebook(authenticationToken)
+	if err != nil {
+		return nil, err
+	}
+	return &types.SharedNotebook{NotebookGuid: &notebook}, nil
+}
+
+func (s *fakeNoteStore) GetLinkedNotebookSyncState(authenticationToken string, linkedNotebook *types.LinkedNotebook) (*notestore.SyncState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetLinkedNotebookSyncState", ""); err != nil {
+		return nil, err
+	}
+	if _, err := s.sharedNotebook(authenticationToken); err != nil {
+		return nil, err
+	}
+	return &notestore.SyncState{CurrentTime: fakeCurrentTime, UpdateCount: s.usn}, nil
+}
+
+func (s *fakeNoteStore) GetLinkedNotebookSyncChunk(authenticationToken string, linkedNotebook *types.LinkedNotebook, afterUSN int32, maxEntries int32, fullSyncOnly bool) (*notestore.SyncChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetLinkedNotebookSyncChunk", ""); err != nil {
+		return nil, err
+	}
+	notebook, err := s.sharedNotebook(authenticationToken)
+	if err != nil {
+		return nil, err
+	}
+	t := true
+	filter := &notestore.SyncChunkFilter{IncludeNotes: &t, IncludeResources: &t, IncludeExpunged: &t}
+	return s.syncChunk(afterUSN, maxEntries, filter, notebook), nil
+}
+
+func (s *fakeNoteStore) ListTagsByNotebook(authenticationToken string, notebookGuid types.GUID) ([]*types.Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListTagsByNotebook", ""); err != nil {
+		return nil, err
+	}
+	return s.tags, nil
+}
+
+func (s *fakeNoteStore) ListNotebooks(authenticationToken string) ([]*types.Notebook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListNotebooks", ""); err != nil {
+		return nil, err
+	}
+	return s.notebooks, nil
+}
+
+func (s *fakeNoteStore) ListTags(authenticationToken string) ([]*types.Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListTags", ""); err != nil {
+		return nil, err
+	}
+	return s.tags, nil
+}
+
+func (s *fakeNoteStore) ListSearches(authenticationToken string) ([]*types.SavedSearch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListSearches", ""); err != nil {
+		return nil, err
+	}
+	return s.searches, nil
+}
+
+// matches is a crude stand-in for the search grammar: every word of the
+// query has to occur in the title or content.
+func matches(note *types.Note, words string) bool {
+	text := strings.ToLower(note.GetTitle() + " " + note.GetContent())
+	for _, word := range strings.Fields(strings.ToLower(words)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fakeNoteStore) FindNotesMetadata(authenticationToken string, filter *notestore.NoteFilter, offset int32, maxNotes int32, resultSpec *notestore.NotesMetadataResultSpec) (*notestore.NotesMetadataList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("FindNotesMetadata", ""); err != nil {
+		return nil, err
+	}
+	var notes []*notestore.NoteMetadata
+	for _, note := range s.sortedNotes() {
+		if !note.GetActive() {
+			continue
+		}
+		if filter.IsSetNotebookGuid() && note.GetNotebookGuid() != string(filter.GetNotebookGuid()) {
+			continue
+		}
+		if err := s.authorize(authenticationToken, note); err != nil {
+			return nil, err
+		}
+		if !matches(note, filter.GetWords()) {
+			continue
+		}
+		meta := &notestore.NoteMetadata{
+			GUID:         note.GetGUID(),
+			Title:        note.Title,
+			Updated:      note.Updated,
+			NotebookGuid: note.NotebookGuid,
+		}
+		if resultSpec.GetIncludeTagGuids() {
+			meta.TagGuids = note.TagGuids
+		}
+		if resultSpec.GetIncludeAttributes() {
+			meta.Attributes = note.Attributes
+		}
+		notes = append(notes, meta)
+	}
+	total := int32(len(notes))
+	if offset > total {
+		offset = total
+	}
+	end := offset + maxNotes
+	if end > total {
+		end = total
+	}
+	return &notestore.NotesMetadataList{
+		StartIndex: offset,
+		TotalNotes: total,
+		Notes:      notes[offset:end],
+	}, nil
+}
+
+func (s *fakeNoteStore) GetNote(authenticationToken string, guid types.GUID, withContent bool, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (*types.Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetNote", string(guid)); err != nil {
+		return nil, err
+	}
+	note, err := s.note(guid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(authenticationToken, note); err != nil {
+		return nil, err
+	}
+	res := *note
+	if !withContent {
+		res.Content = nil
+	}
+	return &res, nil
+}
+
+func (s *fakeNoteStore) ListNoteVersions(authenticationToken string, noteGuid types.GUID) ([]*notestore.NoteVersionId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ListNoteVersions", string(noteGuid)); err != nil {
+		return nil, err
+	}
+	var res []*notestore.NoteVersionId
+	for _, v := range s.versions[string(noteGuid)] {
+		res = append(res, &notestore.NoteVersionId{
+			UpdateSequenceNum: v.GetUpdateSequenceNum(),
+			Updated:           v.GetUpdated(),
+			Saved:             v.GetUpdated(),
+			Title:             v.GetTitle(),
+		})
+	}
+	return res, nil
+}
+
+func (s *fakeNoteStore) GetNoteVersion(authenticationToken string, noteGuid types.GUID, updateSequenceNum int32, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (*types.Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetNoteVersion", string(noteGuid)); err != nil {
+		return nil, err
+	}
+	for _, v := range s.versions[string(noteGuid)] {
+		if v.GetUpdateSequenceNum() == updateSequenceNum {
+			return v, nil
+		}
+	}
+	identifier := "Note.updateSequenceNum"
+	return nil, &edam.EDAMNotFoundException{Identifier: &identifier}
+}
+
+func (s *fakeNoteStore) GetNoteApplicationDataEntry(authenticationToken string, guid types.GUID, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetNoteApplicationDataEntry", string(guid)); err != nil {
+		return "", err
+	}
+	if _, err := s.note(guid); err != nil {
+		return "", err
+	}
+	value, ok := s.appData[string(guid)][key]
+	if !ok {
+		identifier := "NoteAttributes.applicationData.key"
+		return "", &edam.EDAMNotFoundException{Identifier: &identifier, Key: &key}
+	}
+	return value, nil
+}
+
+// SetNoteApplicationDataEntry stores an entry and, like the service, bumps
+// the note's USN but not its updated time.
+func (s *fakeNoteStore) SetNoteApplicationDataEntry(authenticationToken string, guid types.GUID, key string, value string) (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("SetNoteApplicationDataEntry", string(guid)); err != nil {
+		return 0, err
+	}
+	if s.readOnly {
+		return 0, &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_PERMISSION_DENIED}
+	}
+	note, err := s.note(guid)
+	if err != nil {
+		return 0, err
+	}
+	if s.appData[string(guid)] == nil {
+		s.appData[string(guid)] = make(map[string]string)
+	}
+	s.appData[string(guid)][key] = value
+	usn := s.nextUSN()
+	note.UpdateSequenceNum = &usn
+	return usn, nil
+}
+
+func (s *fakeNoteStore) FindRelated(authenticationToken string, query *notestore.RelatedQuery, resultSpec *notestore.RelatedResultSpec) (*notestore.RelatedResult_, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("FindRelated", query.GetNoteGuid()); err != nil {
+		return nil, err
+	}
+	if s.related == nil {
+		return nil, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "findRelated is not supported")
+	}
+	res := &notestore.RelatedResult_{}
+	for _, guid := range s.related[query.GetNoteGuid()] {
+		note := s.notes[guid]
+		if note == nil || string(note.GetNotebookGuid()) != string(query.GetFilter().GetNotebookGuid()) {
+			continue
+		}
+		if len(res.Notes) < int(resultSpec.GetMaxNotes()) {
+			res.Notes = append(res.Notes, note)
+		}
+	}
+	return res, nil
+}
+
+func (s *fakeNoteStore) GetResourceByHash(authenticationToken string, noteGuid types.GUID, contentHash []byte, withData bool, withRecognition bool, withAlternateData bool) (*types.Resource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GetResourceByHash", string(noteGuid)); err != nil {
+		return nil, err
+	}
+	note, err := s.note(noteGuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(authenticationToken, note); err != nil {
+		return nil, err
+	}
+	for _, r := range note.Resources {
+		if string(r.Data.BodyHash) == string(contentHash) {
+			res := *r
+			if !withRecognition {
+				res.Recognition = nil
+			}
+			return &res, nil
+		}
+	}
+	identifier := "Resource.data.bodyHash"
+	return nil, &edam.EDAMNotFoundException{Identifier: &identifier}
+}
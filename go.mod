@@ -0,0 +1,26 @@
+module github.com/zhaojkun/yinxiangblog
+
+go 1.23
+
+require (
+	github.com/PuerkitoBio/goquery v1.10.0
+	github.com/alecthomas/chroma/v2 v2.14.0
+	github.com/apache/thrift v0.0.0
+	github.com/dreampuf/evernote-sdk-golang v0.0.0
+	github.com/mrjones/oauth v0.0.0
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/net v0.29.0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/dlclark/regexp2 v1.11.0 // indirect
+)
+
+// The Evernote SDK and the versions of thrift and oauth it was built
+// against are kept in third_party, as they were pinned before modules.
+replace (
+	github.com/apache/thrift => ./third_party/thrift
+	github.com/dreampuf/evernote-sdk-golang => ./third_party/evernote-sdk-golang
+	github.com/mrjones/oauth => ./third_party/oauth
+)
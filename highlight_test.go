@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildHighlight(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-4", "code", `<en-note><div style="-en-codeblock: true;"><div>lang: go</div><div>x := 1</div></div></en-note>`)
+	cfg := testConfig()
+	cfg.HighlightStyle = "monokai"
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "code.html"))
+	if want := `<pre class="chroma"><code class="language-go"><span class="nx">x</span> <span class="o">:=</span> <span class="mi">1</span></code></pre>`; !strings.Contains(string(page), want) {
+		t.Errorf("page lacks %s:\n%s", want, page)
+	}
+	if css, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "highlight.css")); !strings.Contains(string(css), "#272822") {
+		t.Errorf("got style sheet %s, %v, want monokai", css, err)
+	}
+
+	cfg.HighlightStyle = "no such style"
+	s = rebuild(f.client(cfg), cfg)
+	f.store.putNote("nb-blog", "note-4", "code", `<en-note><div>no code</div></en-note>`)
+	if err := s.Build(); err == nil {
+		t.Error("built with an unknown highlight style")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"sort"
+	"time"
+
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// errNoHistory is returned by ListVersions when the account doesn't keep
+// note history, as only premium accounts do.
+var errNoHistory = errors.New("note history not available")
+
+// ListVersions returns the saved versions of a note. Once the service
+// refused to list them, the client stops asking and returns errNoHistory.
+func (c *Client) ListVersions(guid string) ([]Version, error) {
+	c.mu.Lock()
+	off := c.noHistory
+	c.mu.Unlock()
+	if off {
+		return nil, errNoHistory
+	}
+	s := c.sessionFor(guid)
+	var list []*notestore.NoteVersionId
+	refused := false
+	err := c.callOn(s, "ListNoteVersions", func(store *notestore.NoteStoreClient) (err error) {
+		list, err = store.ListNoteVersions(s.token, types.GUID(guid))
+		if _, ok := err.(*edam.EDAMUserException); ok {
+			refused, err = true, nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refused {
+		c.mu.Lock()
+		if !c.noHistory {
+			log.Println("the account keeps no note history, writing no history pages")
+		}
+		c.noHistory = true
+		c.mu.Unlock()
+		return nil, errNoHistory
+	}
+	versions := make([]Version, 0, len(list))
+	for _, v := range list {
+		versions = append(versions, Version{USN: v.UpdateSequenceNum, Saved: int64(v.Saved), Title: v.Title})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].USN < versions[j].USN })
+	return versions, nil
+}
+
+func (c *Client) FetchVersion(guid string, usn int32) (string, error) {
+	s := c.sessionFor(guid)
+	var note *types.Note
+	err := c.callOn(s, "GetNoteVersion", func(store *notestore.NoteStoreClient) (err error) {
+		note, err = store.GetNoteVersion(s.token, types.GUID(guid), usn, false, false, false)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return note.GetContent(), nil
+}
+
+// historyEntry is one version on a history page, with its changes to the
+// version before.
+type historyEntry struct {
+	Saved string
+	Title string
+	Diff  template.HTML
+}
+
+// historyName is the name of the history page of a post.
+func historyName(post Post) string {
+	return post.Slug + ".history"
+}
+
+// writeHistory writes the history page of a post, whose current ENML is
+// content, without front matter, and returns its link. Posts without past
+// versions, or of accounts which keep none, get no page.
+func (s *Site) writeHistory(vs VersionSource, post Post, content string) (string, error) {
+	var versions []Version
+	err := s.download(func() (err error) {
+		versions, err = vs.ListVersions(post.GUID)
+		return err
+	})
+	if err == errNoHistory {
+		return "", nil
+	}
+	if err != nil || len(versions) == 0 {
+		return "", err
+	}
+	if n := len(versions) - s.cfg.History; n > 0 {
+		versions = versions[n:]
+	}
+	texts := make([]string, len(versions)+1)
+	for i, v := range versions {
+		var enml string
+		err := s.download(func() (err error) {
+			enml, err = vs.FetchVersion(post.GUID, v.USN)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		// content comes without its front matter; neither do versions
+		_, body, err := utils.FrontMatter(enml)
+		if err != nil {
+			return "", err
+		}
+		if texts[i], err = utils.Text(body); err != nil {
+			return "", err
+		}
+	}
+	if texts[len(versions)], err = utils.Text(content); err != nil {
+		return "", err
+	}
+	versions = append(versions, Version{Saved: post.Update, Title: post.Title})
+	// newest first, each diffed against the one before; the oldest shown
+	// version has nothing to compare with.
+	entries := make([]historyEntry, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		diff := template.HTMLEscapeString(texts[i])
+		if i > 0 {
+			diff = utils.DiffHTML(texts[i-1], texts[i])
+		}
+		entries = append(entries, historyEntry{
+			Saved: formatTime(versions[i].Saved),
+			Title: versions[i].Title,
+			Diff:  template.HTML(diff),
+		})
+	}
+	name := historyName(post)
+	page := generateHistory(post, entries)
+	if err := writeContent(s.cfg.ReleaseDir, name, "html", page); err != nil {
+		return "", err
+	}
+	return (&url.URL{Path: name + ".html"}).String(), nil
+}
+
+// formatTime formats a timestamp in milliseconds.
+func formatTime(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04")
+}
+
+func generateHistory(post Post, entries []historyEntry) string {
+	data := map[string]interface{}{
+		"Title":    post.Title,
+		"Link":     post.link(),
+		"Versions": entries,
+	}
+	tpl, err := template.ParseFiles("template/history.html")
+	if err != nil {
+		var content string
+		for _, e := range entries {
+			content += fmt.Sprintf("<h2>%s</h2><pre>%s</pre>", e.Saved, e.Diff)
+		}
+		return content
+	}
+	var buf bytes.Buffer
+	tpl.Execute(&buf, data)
+	return buf.String()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// imageSizes tells browsers how wide images are shown: across small
+// screens, and at most as wide as the post column.
+const imageSizes = "(max-width: 800px) 100vw, 800px"
+
+// imageFile is an image of a post and its downscaled variants.
+type imageFile struct {
+	// name is its file within the release dir, unless it is inlined.
+	name          string
+	width, height int
+	// variants are narrowest first.
+	variants []imageVariant
+}
+
+type imageVariant struct {
+	name  string
+	width int
+}
+
+// files returns the files of the image within the release dir.
+func (img imageFile) files() []string {
+	files := []string{img.name}
+	for _, v := range img.variants {
+		files = append(files, v.name)
+	}
+	return files
+}
+
+// html returns the img element showing the image from src.
+func (img imageFile) html(src, alt string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<img src="%s"`, html.EscapeString(src))
+	if len(img.variants) > 0 {
+		var set []string
+		for _, v := range img.variants {
+			set = append(set, fmt.Sprintf("%s %dw", v.name, v.width))
+		}
+		set = append(set, fmt.Sprintf("%s %dw", img.name, img.width))
+		fmt.Fprintf(&buf, ` srcset="%s" sizes="%s"`, html.EscapeString(strings.Join(set, ", ")), imageSizes)
+	}
+	if img.width > 0 && img.height > 0 {
+		fmt.Fprintf(&buf, ` width="%d" height="%d"`, img.width, img.height)
+	}
+	fmt.Fprintf(&buf, ` loading="lazy" alt="%s"/>`, html.EscapeString(alt))
+	return buf.String()
+}
+
+// imageInfo returns the size of an image as shown, upright, as given by
+// the source or else read from its header.
+func imageInfo(res *Resource) imageFile {
+	img := imageFile{width: res.Width, height: res.Height}
+	if img.width <= 0 || img.height <= 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(res.Data)); err == nil {
+			img.width, img.height = cfg.Width, cfg.Height
+		}
+	}
+	if utils.Orientation(res.Data) >= 5 {
+		img.width, img.height = img.height, img.width
+	}
+	return img
+}
+
+func imageExt(mime string) string {
+	if ext, ok := extensions[mime]; ok {
+		return ext
+	}
+	return "." + strings.TrimPrefix(mime, "image/")
+}
+
+// writeImage writes an image to the images directory, along with a variant
+// of a JPEG or PNG image for every configured width below its own. Files
+// are named by the content hash, so those an earlier post or build wrote
+// are kept as they are. Variants carry no EXIF orientation, so those of
+// photos taken sideways are turned upright, and named apart from the
+// sideways ones earlier builds made.
+func (s *Site) writeImage(res *Resource) (imageFile, error) {
+	img := imageInfo(res)
+	ext := imageExt(res.Mime)
+	img.name = path.Join(imageDir, res.Hash+ext)
+	if err := s.writeAsset(img.name, res.Data); err != nil {
+		return img, err
+	}
+	if res.Mime != "image/jpeg" && res.Mime != "image/png" {
+		return img, nil
+	}
+	orientation := utils.Orientation(res.Data)
+	suffix := ext
+	if orientation != 1 {
+		suffix = "-upright" + ext
+	}
+	var decoded image.Image
+	for _, width := range s.cfg.imageWidths() {
+		if width <= 0 || width >= img.width || (len(img.variants) > 0 && width == img.variants[len(img.variants)-1].width) {
+			continue
+		}
+		v := imageVariant{name: path.Join(imageDir, fmt.Sprintf("%s-%d%s", res.Hash, width, suffix)), width: width}
+		if _, err := os.Stat(path.Join(s.cfg.ReleaseDir, v.name)); err != nil {
+			if decoded == nil {
+				d, _, err := image.Decode(bytes.NewReader(res.Data))
+				if err != nil {
+					log.Println("can't decode image", res.Hash, err)
+					img.variants = nil
+					return img, nil
+				}
+				decoded = utils.Orient(d, orientation)
+			}
+			var buf bytes.Buffer
+			var err error
+			if res.Mime == "image/png" {
+				err = png.Encode(&buf, utils.Resize(decoded, width))
+			} else {
+				err = jpeg.Encode(&buf, utils.Resize(decoded, width), &jpeg.Options{Quality: 85})
+			}
+			if err != nil {
+				return img, err
+			}
+			if err := s.writeAsset(v.name, buf.Bytes()); err != nil {
+				return img, err
+			}
+		}
+		img.variants = append(img.variants, v)
+	}
+	return img, nil
+}
+
+// writeAsset writes a content-addressed file to the release dir, unless it
+// is there already.
+func (s *Site) writeAsset(name string, data []byte) error {
+	p := path.Join(s.cfg.ReleaseDir, name)
+	if fi, err := os.Stat(p); err == nil && fi.Size() == int64(len(data)) {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
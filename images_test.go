@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildImageVariants(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1000, 500))
+	for x := 0; x < 1000; x++ {
+		img.Set(x, x%500, color.NRGBA{uint8(x), 0, 0, 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	f := newFixture()
+	defer f.Close()
+	r := fakeResource("image/png", buf.Bytes())
+	hash := hex.EncodeToString(r.Data.BodyHash)
+	enml := `<en-note><div><en-media hash="` + hash + `" type="image/png"/></div></en-note>`
+	f.store.putNote("nb-blog", "note-4", "big", enml, r)
+	cfg := testConfig()
+	cfg.ImageWidths = []int{2000, 200, 600}
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "big.html"))
+	want := `<img src="images/` + hash + `.png" srcset="images/` + hash + `-200.png 200w, images/` + hash + `-600.png 600w, images/` + hash + `.png 1000w" sizes="` + imageSizes + `" width="1000" height="500" loading="lazy" alt=""/>`
+	if !strings.Contains(string(page), want) {
+		t.Errorf("page lacks %s:\n%s", want, page)
+	}
+	small := filepath.Join(cfg.ReleaseDir, "images", hash+"-200.png")
+	fd, err := os.Open(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := png.DecodeConfig(fd)
+	fd.Close()
+	if err != nil || conf.Width != 200 || conf.Height != 100 {
+		t.Errorf("got a %dx%d variant, %v, want 200x100", conf.Width, conf.Height, err)
+	}
+
+	// variants are made once per hash
+	if err := ioutil.WriteFile(small, []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.store.putNote("nb-blog", "note-4", "big", enml+" ", r)
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(small); string(buf) != "cached" {
+		t.Error("variant made again for an unchanged image")
+	}
+}
+
+func TestBuildImageVariantsUpright(t *testing.T) {
+	// a photo taken sideways: stored 1000x500, red left and blue right,
+	// with an EXIF orientation of 6, shown turned clockwise
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for x := 0; x < 1000; x++ {
+		for y := 0; y < 500; y++ {
+			if x < 500 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x01\x00" +
+		"\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	var photo []byte
+	photo = append(photo, buf.Bytes()[:2]...)
+	photo = append(photo, 0xff, 0xe1, 0, byte(len(exif)+2))
+	photo = append(photo, exif...)
+	photo = append(photo, buf.Bytes()[2:]...)
+
+	f := newFixture()
+	defer f.Close()
+	r := fakeResource("image/jpeg", photo)
+	hash := hex.EncodeToString(r.Data.BodyHash)
+	f.store.putNote("nb-blog", "note-4", "photo", `<en-note><div><en-media hash="`+hash+`" type="image/jpeg"/></div></en-note>`, r)
+	cfg := testConfig()
+	cfg.ImageWidths = []int{200}
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "photo.html"))
+	want := `srcset="images/` + hash + `-200-upright.jpg 200w, images/` + hash + `.jpg 500w" sizes="` + imageSizes + `" width="500" height="1000"`
+	if !strings.Contains(string(page), want) {
+		t.Errorf("page lacks %s:\n%s", want, page)
+	}
+	fd, err := os.Open(filepath.Join(cfg.ReleaseDir, "images", hash+"-200-upright.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	variant, err := jpeg.Decode(fd)
+	fd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := variant.Bounds(); b.Dx() != 200 || b.Dy() != 400 {
+		t.Fatalf("got a %dx%d variant, want 200x400", b.Dx(), b.Dy())
+	}
+	top, _, _, _ := variant.At(100, 50).RGBA()
+	bottom, _, _, _ := variant.At(100, 350).RGBA()
+	if top < 0xc000 || bottom > 0x4000 {
+		t.Errorf("variant not upright: red %x at the top, %x at the bottom", top, bottom)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/dreampuf/evernote-sdk-golang/userstore"
+)
+
+// linkedNotebook is a notebook of another account shared with the blog's
+// account. Its notes are read from the owner's NoteStore with the token the
+// share was authenticated with.
+type linkedNotebook struct {
+	*types.LinkedNotebook
+	session *session
+}
+
+// resolveLinked adds the linked notebooks named in the config, or on the
+// configured stack, to notebooks.
+func (c *Client) resolveLinked(notebooks map[string]string) error {
+	want := make(map[string]string)
+	for _, name := range c.cfg.LinkedNotebooks {
+		want[strings.ToLower(name)] = name
+	}
+	stack := c.cfg.EvernoteStack
+	if len(want) == 0 && stack == "" {
+		return nil
+	}
+	var list []*types.LinkedNotebook
+	err := c.call("ListLinkedNotebooks", func(store *notestore.NoteStoreClient) (err error) {
+		list, err = store.ListLinkedNotebooks(c.token)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.linked = make(map[string]*linkedNotebook)
+	for _, ln := range list {
+		name := strings.ToLower(ln.GetShareName())
+		if _, ok := want[name]; !ok && (stack == "" || ln.GetStack() != stack) {
+			continue
+		}
+		guid, err := c.link(ln)
+		if err != nil {
+			return err
+		}
+		notebooks[guid] = ln.GetShareName()
+		delete(want, name)
+	}
+	for _, name := range want {
+		return fmt.Errorf("linked notebook %s not found", name)
+	}
+	return nil
+}
+
+// link authenticates to the share of a linked notebook and returns the guid
+// of the notebook in its owner's account.
+func (c *Client) link(ln *types.LinkedNotebook) (string, error) {
+	s := newLinkedSession(ln.GetNoteStoreUrl(), c.session.http)
+	var auth *userstore.AuthenticationResult_
+	err := c.callOn(s, "AuthenticateToSharedNotebook", func(store *notestore.NoteStoreClient) (err error) {
+		auth, err = store.AuthenticateToSharedNotebook(ln.GetShareKey(), c.token)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	s.token = auth.AuthenticationToken
+	var shared *types.SharedNotebook
+	err = c.callOn(s, "GetSharedNotebookByAuth", func(store *notestore.NoteStoreClient) (err error) {
+		shared, err = store.GetSharedNotebookByAuth(s.token)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	guid := shared.GetNotebookGuid()
+	c.linked[guid] = &linkedNotebook{LinkedNotebook: ln, session: s}
+	return guid, nil
+}
+
+// linkedGUIDs returns the guids of the linked blog notebooks, sorted.
+func (c *Client) linkedGUIDs() []string {
+	guids := make([]string, 0, len(c.linked))
+	for guid := range c.linked {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids
+}
+
+// sessionOf returns the session to read the notes of a notebook with.
+func (c *Client) sessionOf(notebook string) *session {
+	if ln, ok := c.linked[notebook]; ok {
+		return ln.session
+	}
+	return c.session
+}
+
+// sessionFor returns the session to fetch a listed post with.
+func (c *Client) sessionFor(guid string) *session {
+	c.mu.Lock()
+	notebook := c.located[guid]
+	c.mu.Unlock()
+	return c.sessionOf(notebook)
+}
+
+// locate remembers the notebook of every post, so that the content of those
+// in linked notebooks is fetched from the right NoteStore.
+func (c *Client) locate(posts map[string]Post) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.located == nil {
+		c.located = make(map[string]string)
+	}
+	for guid, p := range posts {
+		c.located[guid] = p.NotebookGUID
+	}
+}
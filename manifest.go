@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// manifestEntry lists the files of the release dir that belong to a post.
+type manifestEntry struct {
+	// Files are its page, history page and assets.
+	Files []string `json:"files"`
+	// Redirects are the redirect stubs left at its former pages.
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+// readManifest reads the files of every post from the manifest of the
+// previous build. Builds from before there was a manifest only wrote the
+// pages of the posts in prev.
+func readManifest(dir string, prev map[string]Post) map[string]manifestEntry {
+	manifest := make(map[string]manifestEntry)
+	buf, err := ioutil.ReadFile(path.Join(dir, "manifest.json"))
+	if err == nil {
+		if err := json.Unmarshal(buf, &manifest); err != nil {
+			log.Println(err)
+		}
+		return manifest
+	}
+	for guid, p := range prev {
+		if p.Slug == "" {
+			p.Slug = slugify(p.Title)
+		}
+		var files []string
+		for _, name := range []string{p.Slug, historyName(p)} {
+			if _, err := os.Stat(path.Join(dir, name+".html")); err == nil {
+				files = append(files, name+".html")
+			}
+		}
+		manifest[guid] = manifestEntry{Files: files}
+	}
+	return manifest
+}
+
+// recordOutput notes the files written for a post: its page, its history
+// page, if any, and assets. With redirects enabled, it also points the
+// former pages of the post to its page: the page of the previous build,
+// named old, and those it was redirected from before.
+func (s *Site) recordOutput(post Post, old string, history bool, assets []string) error {
+	page := post.Slug + ".html"
+	entry := manifestEntry{Files: []string{page}}
+	if history {
+		entry.Files = append(entry.Files, historyName(post)+".html")
+	}
+	entry.Files = append(entry.Files, assets...)
+	if s.cfg.Redirects {
+		from := s.manifest[post.GUID].Redirects
+		if old != "" && old != post.Slug {
+			from = append(from, old+".html")
+		}
+		seen := map[string]bool{page: true}
+		for _, name := range from {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if err := ioutil.WriteFile(path.Join(s.cfg.ReleaseDir, name), []byte(redirectStub(post)), 0755); err != nil {
+				return err
+			}
+			entry.Redirects = append(entry.Redirects, name)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs[post.GUID] = entry
+	return nil
+}
+
+func redirectStub(post Post) string {
+	link := html.EscapeString(post.link())
+	title := html.EscapeString(post.Title)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta http-equiv="refresh" content="0; url=%s">
+	<link rel="canonical" href="%s">
+	<title>%s</title>
+</head>
+<body>
+	<p>Moved to <a href="%s">%s</a>.</p>
+</body>
+</html>
+`, link, link, title, link, title)
+}
+
+// WriteManifest writes the files of the published posts, those written in
+// this build or else those of the previous build, and removes the files of
+// the previous build which no post has any more: the pages of deleted,
+// trashed, hidden or expired posts, the former pages of renamed ones and
+// their assets.
+func (s *Site) WriteManifest(posts map[string]Post) error {
+	manifest := make(map[string]manifestEntry)
+	keep := make(map[string]bool)
+	for guid, p := range posts {
+		if !p.Published {
+			continue
+		}
+		entry, ok := s.outputs[guid]
+		if !ok {
+			entry = s.manifest[guid]
+		}
+		manifest[guid] = entry
+		for _, name := range append(entry.Files, entry.Redirects...) {
+			keep[name] = true
+		}
+	}
+	for _, entry := range s.manifest {
+		for _, name := range append(entry.Files, entry.Redirects...) {
+			if keep[name] {
+				continue
+			}
+			keep[name] = true
+			log.Println("remove orphaned", name)
+			if err := os.Remove(path.Join(s.cfg.ReleaseDir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			// and the directories of assets left empty
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				if os.Remove(path.Join(s.cfg.ReleaseDir, dir)) != nil {
+					break
+				}
+			}
+		}
+	}
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeContent(s.cfg.ReleaseDir, "manifest", "json", string(buf))
+}
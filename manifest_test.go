@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildRemovesOrphans(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-4", "doomed", helloENML)
+	cfg := testConfig()
+	cfg.Redirects = true
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	build := func() {
+		s = rebuild(f.client(cfg), cfg)
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(cfg.ReleaseDir, name))
+		return err == nil
+	}
+	build()
+	// as if the first build predated the manifest
+	if err := os.Remove(filepath.Join(cfg.ReleaseDir, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.store.trashNote("note-4")
+	f.store.expungeNote("note-2")
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>---</div><div>slug: hello</div><div>---</div><div>first post</div></en-note>`)
+	build()
+	if exists("doomed.html") || exists("test pic.html") {
+		t.Error("pages of the trashed or deleted notes still online")
+	}
+	if !exists("hello.html") {
+		t.Fatal("no page at the new slug")
+	}
+	stub, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "hello world.html"))
+	if err != nil || !strings.Contains(string(stub), `url=hello.html`) {
+		t.Errorf("got %q, %v at the old slug, want a redirect", stub, err)
+	}
+
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>---</div><div>slug: hi</div><div>---</div><div>first post</div></en-note>`)
+	build()
+	for _, name := range []string{"hello world.html", "hello.html"} {
+		if stub, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, name)); !strings.Contains(string(stub), `url=hi.html`) {
+			t.Errorf("%s doesn't redirect to the new slug: %s", name, stub)
+		}
+	}
+
+	cfg.Redirects = false
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>---</div><div>slug: hi</div><div>---</div><div>first post again</div></en-note>`)
+	build()
+	if exists("hello world.html") || exists("hello.html") || !exists("hi.html") {
+		t.Error("redirects kept after turning them off")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMarkdown(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.addTag("tag-md", "Markdown")
+	f.store.putNote("nb-blog", "note-4", "md", `<en-note><div>## Notes</div><div><br/></div>`+
+		`<div>A **picture**:</div><div><en-media hash="2b40b9355fdeec3aa717675b01e6d28d" type="image/png"/></div></en-note>`,
+		fakeResource("image/png", []byte(picPNG)))
+	f.store.tagNote("note-4", "tag-md")
+	f.store.putNote("nb-blog", "note-5", "marked", `<en-note><div>&lt;!-- markdown --&gt;</div><div>*marked*</div></en-note>`)
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"md.html": `<div class="note markdown"><h2>Notes</h2>` + "\n" +
+			`<p>A <strong>picture</strong>:` + "\n" + `<img src="images/2b40b9355fdeec3aa717675b01e6d28d.png" width="1" height="1" loading="lazy" alt=""/></p>`,
+		"marked.html":   `<div class="note markdown"><p><em>marked</em></p>`,
+		"test pic.html": `<div class="note"><div>here is a image</div>`,
+	} {
+		page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, name))
+		if !strings.Contains(string(page), want) {
+			t.Errorf("%s lacks %s:\n%s", name, want, page)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+const (
+	// attachmentDir is the directory of the release dir attachments are
+	// written to, one directory per content hash.
+	attachmentDir = "attachments"
+	// imageDir is the directory of the release dir images are written to,
+	// named by their content hash, so that posts share them.
+	imageDir = "images"
+)
+
+// extensions are the file extensions of images and of attachments without
+// a file name.
+var extensions = map[string]string{
+	"image/png":        ".png",
+	"image/jpeg":       ".jpg",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/svg+xml":    ".svg",
+	"image/bmp":        ".bmp",
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"audio/mpeg":       ".mp3",
+	"audio/mp4":        ".m4a",
+	"audio/wav":        ".wav",
+	"audio/x-m4a":      ".m4a",
+	"audio/amr":        ".amr",
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"text/plain":       ".txt",
+	"application/json": ".json",
+}
+
+// render converts the ENML of a post into HTML, or the Markdown it is
+// written in. Its resources are downloaded concurrently, each distinct hash
+// once: images are written to the images directory, or inlined with
+// InlineImages, other files written to the attachments directory and
+// linked or embedded as a player. Images without a caption of their own
+// get the text recognised in them as alt text; that text is returned as
+// well, one line per image, along with the files written. Images which
+// don't decode are left out.
+func (s *Site) render(post Post, enml string) (string, string, []string, error) {
+	media, err := utils.MediaOf(enml)
+	if err != nil {
+		return "", "", nil, err
+	}
+	var hashes []string
+	resources := make(map[string]*Resource)
+	for _, m := range media {
+		if m.Hash == "" {
+			continue
+		}
+		if _, ok := resources[m.Hash]; !ok {
+			resources[m.Hash] = nil
+			hashes = append(hashes, m.Hash)
+		}
+	}
+	errs := make([]error, len(hashes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, hash := range hashes {
+		wg.Add(1)
+		go func(i int, hash string) {
+			defer wg.Done()
+			var res *Resource
+			errs[i] = s.download(func() (err error) {
+				res, err = s.src.FetchResource(post.GUID, hash)
+				return err
+			})
+			if errs[i] != nil {
+				return
+			}
+			log.Println("fetch resource", hash, res.Mime, len(res.Data))
+			mu.Lock()
+			resources[hash] = res
+			mu.Unlock()
+		}(i, hash)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	for hash, res := range resources {
+		if res, err = s.scrubResource(res); err != nil {
+			return "", "", nil, err
+		}
+		if res == nil {
+			delete(resources, hash)
+			continue
+		}
+		resources[hash] = res
+	}
+	var text, files []string
+	links := make(map[string]string)
+	images := make(map[string]imageFile)
+	for _, hash := range hashes {
+		res, ok := resources[hash]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(res.Mime, "image/") {
+			if res.Text != "" {
+				text = append(text, res.Text)
+			}
+			if s.cfg.InlineImages {
+				images[hash] = imageInfo(res)
+				continue
+			}
+			img, err := s.writeImage(res)
+			if err != nil {
+				return "", "", nil, err
+			}
+			files = append(files, img.files()...)
+			images[hash] = img
+			continue
+		}
+		name, err := s.writeAttachment(res)
+		if err != nil {
+			return "", "", nil, err
+		}
+		files = append(files, name)
+		links[hash] = name
+	}
+	convert := utils.Convert
+	markdown, err := utils.IsMarkdown(enml)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if markdown || s.cfg.markdown(post.Tags) {
+		convert = utils.ConvertMarkdown
+	}
+	content, unknown, err := convert(enml, func(m utils.Media) string {
+		res, ok := resources[m.Hash]
+		if !ok {
+			return ""
+		}
+		if !strings.HasPrefix(res.Mime, "image/") {
+			return attachmentHTML(res, links[m.Hash])
+		}
+		alt := m.Attrs["alt"]
+		if alt == "" {
+			alt = m.Attrs["title"]
+		}
+		if alt == "" {
+			alt = res.Text
+		}
+		img := images[m.Hash]
+		src := img.name
+		if src == "" {
+			src = "data:" + m.Type + ";base64," + base64.StdEncoding.EncodeToString(res.Data)
+		}
+		return img.html(src, alt)
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(unknown) > 0 {
+		log.Println("post", post.Title, "has unknown elements", strings.Join(unknown, ", "))
+	}
+	return content, strings.Join(text, "\n"), files, nil
+}
+
+// writeAttachment writes a resource under its file name to the attachments
+// directory and returns its name within the release dir.
+func (s *Site) writeAttachment(res *Resource) (string, error) {
+	name := attachmentName(res)
+	p := path.Join(s.cfg.ReleaseDir, name)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	return name, ioutil.WriteFile(p, res.Data, 0644)
+}
+
+// attachmentName returns where an attachment goes: its file name, made
+// safe, in the directory of its hash, so that files of the same name
+// don't clash.
+func attachmentName(res *Resource) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimLeft(res.FileName, "."))
+	if name == "" {
+		name = res.Hash + extensions[res.Mime]
+	}
+	return path.Join(attachmentDir, res.Hash, name)
+}
+
+// attachmentHTML embeds audio and video in a player and links any other
+// file along with its size.
+func attachmentHTML(res *Resource, name string) string {
+	link := html.EscapeString(attachmentDir + "/" + res.Hash + "/" + url.PathEscape(path.Base(name)))
+	title := html.EscapeString(path.Base(name))
+	switch {
+	case strings.HasPrefix(res.Mime, "audio/"):
+		return fmt.Sprintf(`<audio controls="controls" src="%s"><a href="%s">%s</a></audio>`, link, link, title)
+	case strings.HasPrefix(res.Mime, "video/"):
+		return fmt.Sprintf(`<video controls="controls" src="%s"><a href="%s">%s</a></video>`, link, link, title)
+	case res.Mime == "application/pdf":
+		return fmt.Sprintf(`<a class="attachment pdf" href="%s" download="download">%s</a> (%s)`, link, title, formatSize(len(res.Data)))
+	}
+	return fmt.Sprintf(`<a class="attachment" href="%s" download="download">%s</a> (%s)`, link, title, formatSize(len(res.Data)))
+}
+
+// formatSize returns a size in bytes for people.
+func formatSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
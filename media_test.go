@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+func TestBuildAttachments(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	attach := func(mime, name string, body []byte) (*types.Resource, string) {
+		r := fakeResource(mime, body)
+		if name != "" {
+			r.Attributes = &types.ResourceAttributes{FileName: &name}
+		}
+		hash := hex.EncodeToString(r.Data.BodyHash)
+		return r, `<div><en-media hash="` + hash + `" type="` + mime + `"/></div>`
+	}
+	pdf, pdfTag := attach("application/pdf", "slides #1.pdf", make([]byte, 2048))
+	song, songTag := attach("audio/mpeg", "song.mp3", []byte("la la la"))
+	clip, clipTag := attach("video/mp4", "../clip.mp4", []byte("action"))
+	blob, blobTag := attach("application/octet-stream", "", []byte("bytes"))
+	f.store.putNote("nb-blog", "note-4", "files", "<en-note>"+pdfTag+songTag+clipTag+blobTag+"</en-note>", pdf, song, clip, blob)
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "files.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func(r *types.Resource) string { return hex.EncodeToString(r.Data.BodyHash) }
+	for _, want := range []string{
+		`<a class="attachment pdf" href="attachments/` + hash(pdf) + `/slides%20%231.pdf" download="download">slides #1.pdf</a> (2.0 KB)`,
+		`<audio controls="controls" src="attachments/` + hash(song) + `/song.mp3">`,
+		`<video controls="controls" src="attachments/` + hash(clip) + `/-clip.mp4">`,
+		`<a class="attachment" href="attachments/` + hash(blob) + `/` + hash(blob) + `" download="download">` + hash(blob) + `</a> (5 B)`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("page lacks %s:\n%s", want, page)
+		}
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "attachments", hash(song), "song.mp3")); string(buf) != "la la la" {
+		t.Errorf("got attachment %q, %v", buf, err)
+	}
+
+	f.store.putNote("nb-blog", "note-4", "files", "<en-note>"+songTag+"</en-note>", song)
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "attachments", hash(pdf))); !os.IsNotExist(err) {
+		t.Error("removed attachment still online")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "attachments", hash(song), "song.mp3")); err != nil {
+		t.Error("kept attachment gone:", err)
+	}
+}
+
+func TestBuildSharedImages(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-4", "same pic", picENML, fakeResource("image/png", []byte(picPNG)))
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	image := filepath.Join(cfg.ReleaseDir, "images", "2b40b9355fdeec3aa717675b01e6d28d.png")
+	build := func() {
+		s = rebuild(f.client(cfg), cfg)
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build()
+	for _, name := range []string{"test pic.html", "same pic.html"} {
+		page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, name))
+		if !strings.Contains(string(page), `<img src="images/2b40b9355fdeec3aa717675b01e6d28d.png" width="1" height="1" loading="lazy" alt=""/>`) {
+			t.Errorf("%s doesn't link the image:\n%s", name, page)
+		}
+	}
+	if buf, err := ioutil.ReadFile(image); string(buf) != picPNG {
+		t.Errorf("got image %q, %v", buf, err)
+	}
+
+	f.store.expungeNote("note-2")
+	build()
+	if _, err := os.Stat(image); err != nil {
+		t.Error("image of the remaining post gone:", err)
+	}
+
+	cfg.InlineImages = true
+	f.store.putNote("nb-blog", "note-4", "same pic", picENML, fakeResource("image/png", []byte(picPNG)))
+	build()
+	page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "same pic.html"))
+	if !strings.Contains(string(page), `<img src="data:image/png;base64,`+base64.StdEncoding.EncodeToString([]byte(picPNG))+`" width="1" height="1" loading="lazy" alt=""/>`) {
+		t.Errorf("image not inlined:\n%s", page)
+	}
+	if _, err := os.Stat(image); !os.IsNotExist(err) {
+		t.Error("image file kept after inlining it")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"strings"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// Location is where a note was taken.
+type Location struct {
+	Place     string  `json:"place,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// noteAuthor returns the author of a note according to its attributes.
+func noteAuthor(attrs *types.NoteAttributes) string {
+	if attrs == nil {
+		return ""
+	}
+	return attrs.GetAuthor()
+}
+
+// noteLocation returns the location of a note according to its
+// attributes, or nil.
+func noteLocation(attrs *types.NoteAttributes) *Location {
+	if attrs == nil || !(attrs.IsSetLatitude() && attrs.IsSetLongitude() || attrs.IsSetPlaceName()) {
+		return nil
+	}
+	return &Location{Place: attrs.GetPlaceName(), Latitude: attrs.GetLatitude(), Longitude: attrs.GetLongitude()}
+}
+
+// allows tells whether the note attribute, "author" or "location", may be
+// published.
+func (cfg *Config) allows(attr string) bool {
+	for _, a := range cfg.PublicAttributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubPost clears the attributes of a post which may not be published,
+// before anything is written.
+func (cfg *Config) scrubPost(p Post) Post {
+	if !cfg.allows("author") {
+		p.Author = ""
+	}
+	if !cfg.allows("location") {
+		p.Location = nil
+	}
+	return p
+}
+
+// scrubResource returns a copy of a JPEG or PNG image without the metadata
+// it carries, unless it is to be kept. Images the metadata can't be cut out
+// of are encoded again, turned upright first as the encoding drops their
+// orientation along with the metadata; those that don't decode either are
+// left out, returning nil, rather than published with what they carry.
+func (s *Site) scrubResource(res *Resource) (*Resource, error) {
+	if s.cfg.KeepMetadata || (res.Mime != "image/jpeg" && res.Mime != "image/png") {
+		return res, nil
+	}
+	data, err := utils.StripMetadata(res.Mime, res.Data)
+	if err != nil {
+		img, _, derr := image.Decode(bytes.NewReader(res.Data))
+		if derr != nil {
+			log.Println("can't read image", res.Hash, derr, "leaving it out")
+			return nil, nil
+		}
+		log.Println("can't strip metadata of image", res.Hash, err, "encoding it again")
+		var buf bytes.Buffer
+		if res.Mime == "image/png" {
+			err = png.Encode(&buf, img)
+		} else {
+			img = utils.Orient(img, utils.Orientation(res.Data))
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	scrubbed := *res
+	scrubbed.Data = data
+	return &scrubbed, nil
+}
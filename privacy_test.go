@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+func TestBuildPrivacy(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00GPS 52.52N 13.40E")
+	photo := append(append(append([]byte(nil), plain[:2]...), 0xff, 0xe1, 0, byte(len(exif)+2)), exif...)
+	photo = append(photo, plain[2:]...)
+
+	f := newFixture()
+	defer f.Close()
+	r := fakeResource("image/jpeg", photo)
+	hash := hex.EncodeToString(r.Data.BodyHash)
+	note := f.store.putNote("nb-blog", "note-4", "trip", `<en-note><div><en-media hash="`+hash+`" type="image/jpeg"/></div></en-note>`, r)
+	author, place := "Anna", "Berlin"
+	lat, lon := 52.52, 13.40
+	note.Attributes = &types.NoteAttributes{Author: &author, PlaceName: &place, Latitude: &lat, Longitude: &lon}
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	build := func() {
+		s = rebuild(f.client(cfg), cfg)
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaks := func(want bool) {
+		for name, secret := range map[string]string{
+			"meta.json":               "Berlin",
+			"trip.html":               "Berlin",
+			"images/" + hash + ".jpg": "GPS",
+		} {
+			buf, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(buf), secret); got != want {
+				t.Errorf("%s shows %s: %v, want %v", name, secret, got, want)
+			}
+		}
+	}
+	build()
+	leaks(false)
+
+	cfg.KeepMetadata = true
+	cfg.PublicAttributes = []string{"author", "Location"}
+	f.store.putNote("nb-blog", "note-4", "trip", note.GetContent()+" ", r).Attributes = note.Attributes
+	build()
+	leaks(true)
+	if page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "trip.html")); !strings.Contains(string(page), "Anna · Berlin") {
+		t.Errorf("page doesn't show the author and place:\n%s", page)
+	}
+}
+
+func TestBuildPrivacyReencoded(t *testing.T) {
+	// a sideways photo with a stray byte after its EXIF segment, which the
+	// metadata can't be cut out of but which still decodes
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x01\x00" +
+		"\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00GPS 52.52N 13.40E")
+	photo := append(append(append([]byte(nil), plain[:2]...), 0xff, 0xe1, 0, byte(len(exif)+2)), exif...)
+	photo = append(append(photo, 0x00), plain[2:]...)
+
+	f := newFixture()
+	defer f.Close()
+	r := fakeResource("image/jpeg", photo)
+	hash := hex.EncodeToString(r.Data.BodyHash)
+	broken := fakeResource("image/jpeg", []byte("GPS 52.52N 13.40E, no picture"))
+	brokenHash := hex.EncodeToString(broken.Data.BodyHash)
+	f.store.putNote("nb-blog", "note-4", "trip", `<en-note><div><en-media hash="`+hash+`" type="image/jpeg"/></div>`+
+		`<div><en-media hash="`+brokenHash+`" type="image/jpeg"/></div></en-note>`, r, broken)
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "images", hash+".jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("GPS")) {
+		t.Error("image still shows GPS")
+	}
+	upright, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := upright.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("got a %dx%d image, want 20x40", b.Dx(), b.Dy())
+	}
+	if top, _, _, _ := upright.At(10, 5).RGBA(); top < 0xc000 {
+		t.Errorf("image not upright: %x at the top", top)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "images", brokenHash+".jpg")); !os.IsNotExist(err) {
+		t.Errorf("unreadable image published: %v", err)
+	}
+	if page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "trip.html")); strings.Contains(string(page), brokenHash) {
+		t.Errorf("page shows the unreadable image:\n%s", page)
+	}
+}
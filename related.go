@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// errNoRelated is returned by FindRelated when the service can't find
+// related notes.
+var errNoRelated = errors.New("related notes not available")
+
+// FindRelated returns the guids of up to max notes of the notebook of post
+// related to it, as found by the service. Once the service turned out not to
+// support it, the client stops asking and returns errNoRelated.
+func (c *Client) FindRelated(post Post, max int) ([]string, error) {
+	c.mu.Lock()
+	off := c.noRelated
+	c.mu.Unlock()
+	if off {
+		return nil, errNoRelated
+	}
+	s := c.sessionOf(post.NotebookGUID)
+	guid := post.GUID
+	notebook := types.GUID(post.NotebookGUID)
+	query := notestore.RelatedQuery{
+		NoteGuid: &guid,
+		Filter:   &notestore.NoteFilter{NotebookGuid: &notebook},
+	}
+	n := int32(max)
+	spec := notestore.RelatedResultSpec{MaxNotes: &n}
+	var res *notestore.RelatedResult_
+	unsupported := false
+	err := c.callOn(s, "FindRelated", func(store *notestore.NoteStoreClient) (err error) {
+		res, err = store.FindRelated(s.token, &query, &spec)
+		switch err.(type) {
+		case thrift.TApplicationException, *edam.EDAMUserException:
+			unsupported, err = true, nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unsupported {
+		c.mu.Lock()
+		if !c.noRelated {
+			log.Println("the service can't find related notes, falling back to local similarity")
+		}
+		c.noRelated = true
+		c.mu.Unlock()
+		return nil, errNoRelated
+	}
+	var guids []string
+	for _, note := range res.GetNotes() {
+		guids = append(guids, string(note.GetGUID()))
+	}
+	return guids, nil
+}
+
+// similarity finds related posts locally, by the cosine similarity of the
+// TF-IDF weights of their search terms and tags.
+type similarity struct {
+	posts   []Post
+	byGUID  map[string]Post
+	weights map[string]map[string]float64
+}
+
+// newSimilarity indexes posts. The terms of a post come from the search
+// index of the previous build, or from its title when it isn't indexed yet,
+// so that the result doesn't depend on the order pages are written in.
+func newSimilarity(posts map[string]Post, index map[string]searchEntry) *similarity {
+	sim := &similarity{
+		posts:   sortPosts(posts),
+		byGUID:  posts,
+		weights: make(map[string]map[string]float64),
+	}
+	df := make(map[string]int)
+	for _, p := range sim.posts {
+		terms := strings.Fields(index[p.GUID].Terms)
+		if len(terms) == 0 {
+			terms = utils.Terms(p.Title)
+		}
+		for _, tag := range p.Tags {
+			terms = append(terms, "#"+strings.ToLower(tag))
+		}
+		w := make(map[string]float64, len(terms))
+		for _, t := range terms {
+			if _, ok := w[t]; !ok {
+				w[t] = 1
+				df[t]++
+			}
+		}
+		sim.weights[p.GUID] = w
+	}
+	for _, w := range sim.weights {
+		var norm float64
+		for t := range w {
+			w[t] = math.Log(float64(len(sim.posts)) / float64(df[t]))
+			norm += w[t] * w[t]
+		}
+		for t := range w {
+			if norm > 0 {
+				w[t] /= math.Sqrt(norm)
+			}
+		}
+	}
+	return sim
+}
+
+// related returns up to max posts most similar to the one with guid, most
+// similar first and newer first among equals. Posts sharing nothing with it
+// are left out.
+func (sim *similarity) related(guid string, max int) []Post {
+	type scored struct {
+		post  Post
+		score float64
+	}
+	w := sim.weights[guid]
+	var res []scored
+	for _, p := range sim.posts {
+		if p.GUID == guid {
+			continue
+		}
+		var score float64
+		for t, v := range sim.weights[p.GUID] {
+			score += v * w[t]
+		}
+		if score > 1e-9 {
+			res = append(res, scored{p, score})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].score > res[j].score
+	})
+	var posts []Post
+	for i := 0; i < len(res) && i < max; i++ {
+		posts = append(posts, res[i].post)
+	}
+	return posts
+}
+
+// relatedPosts returns the posts to list as related to post: those the
+// source finds, if it can, or else the most similar ones.
+func (s *Site) relatedPosts(post Post) ([]Post, error) {
+	max := s.cfg.Related
+	if max <= 0 || s.similar == nil {
+		return nil, nil
+	}
+	rs, ok := s.src.(RelatedSource)
+	if !ok {
+		return s.similar.related(post.GUID, max), nil
+	}
+	var guids []string
+	err := s.download(func() (err error) {
+		guids, err = rs.FindRelated(post, max)
+		return err
+	})
+	if err == errNoRelated {
+		return s.similar.related(post.GUID, max), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	var posts []Post
+	for _, guid := range guids {
+		// only link posts which are on the blog
+		if p, ok := s.similar.byGUID[guid]; ok && guid != post.GUID {
+			posts = append(posts, p)
+		}
+	}
+	return posts, nil
+}
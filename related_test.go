@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// relatedLinks returns the related posts listed on a page.
+func relatedLinks(t *testing.T, dir, name string) string {
+	page, err := ioutil.ReadFile(filepath.Join(dir, name+".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(page)
+	i := strings.Index(s, `<ul class="related">`)
+	if i < 0 {
+		return ""
+	}
+	s = s[i:strings.Index(s, "</ul>")]
+	var titles []string
+	for _, part := range strings.Split(s, `.html">`)[1:] {
+		titles = append(titles, part[:strings.Index(part, "</a>")])
+	}
+	return strings.Join(titles, ", ")
+}
+
+func TestBuildRelatedLocal(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.addTag("tag-go", "go")
+	f.store.putNote("nb-blog", "note-4", "hello again", helloENML)
+	f.store.putNote("nb-blog", "note-5", "gophers", helloENML)
+	f.store.tagNote("note-1", "tag-go")
+	f.store.tagNote("note-5", "tag-go")
+	cfg := testConfig()
+	cfg.Related = 5
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relatedLinks(t, cfg.ReleaseDir, "hello world"), "gophers, hello again"; got != want {
+		t.Errorf("hello world lists %q as related, want %q", got, want)
+	}
+	if got := relatedLinks(t, cfg.ReleaseDir, "test pic"); got != "" {
+		t.Errorf("test pic lists %q as related, want none", got)
+	}
+	if n := f.store.count("FindRelated note-1") + f.store.count("FindRelated note-2"); n > 2 {
+		t.Errorf("asked the service %d times, want it to stop once unsupported", n)
+	}
+}
+
+func TestBuildRelatedService(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-4", "hello again", helloENML)
+	f.store.related = map[string][]string{
+		"note-1": {"note-2", "note-3", "note-4"},
+	}
+	cfg := testConfig()
+	cfg.Related = 1
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relatedLinks(t, cfg.ReleaseDir, "hello world"), "test pic"; got != want {
+		t.Errorf("hello world lists %q as related, want %q", got, want)
+	}
+	if got := relatedLinks(t, cfg.ReleaseDir, "hello again"); got != "" {
+		t.Errorf("hello again lists %q as related, want none", got)
+	}
+}
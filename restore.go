@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// releaseArchive is where a tar.gz of the published branch is downloaded
+// from and releaseMeta its meta.json, given the user, project and branch;
+// tests serve their own.
+var (
+	releaseArchive = "https://codeload.github.com/%s/%s/tar.gz/%s"
+	releaseMeta    = "https://raw.githubusercontent.com/%s/%s/%s/meta.json"
+)
+
+// restoreRelease fills a release dir without a previous build with the
+// blog as last published, so that a build on a fresh checkout, as on CI,
+// only renders the notes changed since. Every file is restored, not only
+// meta.json, sync.json and the manifest, as the deploy script publishes
+// the release dir as a whole. When the branch can't be downloaded, the
+// build starts over from scratch.
+func (s *Site) restoreRelease() {
+	user, project, branch := s.cfg.ReleaseUserName, s.cfg.ReleaseProject, s.cfg.ReleaseBranch
+	if user == "" || project == "" || branch == "" {
+		return
+	}
+	if _, err := os.Stat(path.Join(s.cfg.ReleaseDir, "meta.json")); err == nil {
+		return
+	}
+	archive := fmt.Sprintf(releaseArchive, user, project, branch)
+	log.Println("restore", archive)
+	resp, err := http.Get(archive)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Println(resp.Status)
+		return
+	}
+	// extract next to the release dir first, so that a failed download
+	// never leaves half a build behind to be updated incrementally.
+	tmp, err := ioutil.TempDir(path.Dir(path.Clean(s.cfg.ReleaseDir)), "restore")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if err := untar(resp.Body, tmp); err != nil {
+		log.Println("restore:", err)
+		return
+	}
+	if err := os.MkdirAll(s.cfg.ReleaseDir, 0755); err != nil {
+		log.Println(err)
+		return
+	}
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, fi := range entries {
+		dst := path.Join(s.cfg.ReleaseDir, fi.Name())
+		os.RemoveAll(dst)
+		if err := os.Rename(path.Join(tmp, fi.Name()), dst); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// untar extracts the regular files of a tar.gz into dir, dropping the
+// top directory GitHub puts them in.
+func untar(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(hdr.Name, "/", 2)
+		if len(parts) < 2 || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(parts[1])
+		if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		p := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
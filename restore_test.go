@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarGz packs the files of dir into a tar.gz under top, as GitHub serves
+// branches.
+func tarGz(t *testing.T, dir, top string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		data, _ := ioutil.ReadFile(p)
+		tw.WriteHeader(&tar.Header{Name: top + "/" + filepath.ToSlash(rel), Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		_, err = tw.Write(data)
+		return err
+	})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestBuildRestoresRelease(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	archive := tarGz(t, cfg.ReleaseDir, "blog-gh-pages")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/blog/tar.gz/gh-pages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	defer func(archive, meta string) { releaseArchive, releaseMeta = archive, meta }(releaseArchive, releaseMeta)
+	releaseArchive = srv.URL + "/%s/%s/tar.gz/%s"
+	releaseMeta = srv.URL + "/%s/%s/%s/meta.json"
+
+	// a fresh checkout, whose release dir is empty
+	cfg.ReleaseUserName, cfg.ReleaseProject, cfg.ReleaseBranch = "me", "blog", "gh-pages"
+	f.store.putNote("nb-blog", "note-1", "hello world", helloENML)
+	s = newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("GetNote note-2"); n != 1 {
+		t.Errorf("unchanged note-2 fetched %d times, want 1", n)
+	}
+	if n := f.store.count("FindNotesMetadata"); n != 1 {
+		t.Errorf("notebook listed %d times, want only on the first build", n)
+	}
+	for _, name := range []string{"test pic.html", "hello world.html", "index.html", "meta.json"} {
+		if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, name)); err != nil {
+			t.Errorf("%s not in the release dir: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(s.marker); err != nil {
+		t.Error("changed marker not written")
+	}
+
+	// nothing published yet: everything is built
+	cfg.ReleaseBranch = "new"
+	s = newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("GetNote note-2"); n != 2 {
+		t.Errorf("note-2 fetched %d times, want 2", n)
+	}
+}
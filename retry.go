@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+)
+
+const (
+	defaultRetries = 5
+	backoffBase    = time.Second
+	backoffMax     = time.Minute
+)
+
+// call runs a NoteStore call of the account, retrying it when the service
+// is rate limited or the transport fails.
+func (c *Client) call(name string, fn func(store *notestore.NoteStoreClient) error) error {
+	return c.callOn(c.session, name, fn)
+}
+
+// callOn runs a call in session s, which is the account's or that of a
+// linked notebook. The NoteStore client of a failed attempt is dropped, as
+// a failed Thrift call can leave its transport in an unknown state.
+func (c *Client) callOn(s *session, name string, fn func(store *notestore.NoteStoreClient) error) error {
+	retries := c.cfg.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	for attempt := 1; ; attempt++ {
+		c.waitPause()
+		store, err := s.get()
+		if err == nil {
+			err = fn(store)
+		}
+		if err == nil {
+			s.put(store)
+			return nil
+		}
+		wait, ok := retryDelay(err, attempt)
+		if !ok || attempt >= retries {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		log.Printf("%s: %v, retry %d/%d in %v", name, err, attempt, retries-1, wait)
+		if isRateLimit(err) {
+			c.pause(wait)
+		}
+		c.sleep(wait)
+	}
+}
+
+// pause holds back the calls of every worker for d, as a rate limit applies
+// to the whole account rather than to the call that hit it.
+func (c *Client) pause(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until := time.Now().Add(d); until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+}
+
+func (c *Client) waitPause() {
+	c.mu.Lock()
+	d := c.pausedUntil.Sub(time.Now())
+	c.mu.Unlock()
+	if d > 0 {
+		c.sleep(d)
+	}
+}
+
+func isRateLimit(err error) bool {
+	e, ok := err.(*edam.EDAMSystemException)
+	return ok && e.ErrorCode == edam.EDAMErrorCode_RATE_LIMIT_REACHED
+}
+
+// retryDelay tells whether err is worth retrying and how long to wait
+// before the given attempt is repeated. Rate limits are waited out for the
+// duration the service asks for, transient errors back off exponentially
+// with jitter, and everything else, like EDAMUserException or
+// EDAMNotFoundException, is final.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *edam.EDAMSystemException:
+		switch e.ErrorCode {
+		case edam.EDAMErrorCode_RATE_LIMIT_REACHED:
+			return time.Duration(e.GetRateLimitDuration()) * time.Second, true
+		case edam.EDAMErrorCode_SHARD_UNAVAILABLE, edam.EDAMErrorCode_INTERNAL_ERROR:
+			return backoff(attempt), true
+		}
+	case thrift.TTransportException:
+		return backoff(attempt), true
+	case net.Error:
+		return backoff(attempt), true
+	}
+	return 0, false
+}
+
+// backoff returns a random delay in [d/2, d) where d doubles with every
+// attempt, up to backoffMax.
+func backoff(attempt int) time.Duration {
+	d := backoffBase << uint(attempt-1)
+	if d > backoffMax || d <= 0 {
+		d = backoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
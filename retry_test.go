@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+)
+
+func rateLimited(seconds int32) error {
+	return &edam.EDAMSystemException{
+		ErrorCode:         edam.EDAMErrorCode_RATE_LIMIT_REACHED,
+		RateLimitDuration: &seconds,
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if d, ok := retryDelay(rateLimited(42), 1); !ok || d != 42*time.Second {
+		t.Errorf("rate limit: got %v %v, want 42s", d, ok)
+	}
+	d, ok := retryDelay(thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION, "HTTP Response code: 503"), 3)
+	if !ok || d < 2*time.Second || d >= 4*time.Second {
+		t.Errorf("transport error: got %v %v, want [2s, 4s)", d, ok)
+	}
+	if _, ok := retryDelay(&edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_PERMISSION_DENIED}, 1); ok {
+		t.Error("user exception retried")
+	}
+	if d := backoff(30); d > backoffMax {
+		t.Errorf("backoff(30) = %v, above %v", d, backoffMax)
+	}
+}
+
+func TestBuildWaitsOutRateLimit(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.fail("GetNote", rateLimited(3))
+	f.goDown(1)
+	cfg := testConfig()
+	cfg.Concurrency = 1
+	c := f.client(cfg)
+	var slept []time.Duration
+	c.sleep = func(d time.Duration) { slept = append(slept, d) }
+	s := newTestSite(t, c, cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) < 2 || slept[0] >= time.Second || slept[1] != 3*time.Second {
+		t.Errorf("slept %v, want a backoff and then 3s", slept)
+	}
+	if len(readMeta(cfg.ReleaseDir)) != 2 {
+		t.Error("posts missing after retries")
+	}
+}
+
+func TestBuildFailsWhenRetriesRunOut(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.fail("GetNote", rateLimited(1), rateLimited(1), rateLimited(1))
+	cfg := testConfig()
+	cfg.Retries = 3
+	cfg.Concurrency = 1
+	c := f.client(cfg)
+	c.sleep = func(time.Duration) {}
+	s := newTestSite(t, c, cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err == nil {
+		t.Fatal("build succeeded")
+	}
+	if _, err := os.Stat(s.marker); err == nil {
+		t.Error("failed build marked the site as changed")
+	}
+}
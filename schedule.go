@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+// visible tells whether a post is on the blog at now, in milliseconds.
+func (p Post) visible(now int64) bool {
+	return (p.PublishAt == 0 || p.PublishAt <= now) && (p.ExpireAt == 0 || now < p.ExpireAt)
+}
+
+// schedule sets the publish and expiry times given in the front matter of
+// a post, which take precedence over those of the note's attributes.
+func (p *Post) schedule(fields map[string]string) error {
+	for _, f := range []struct {
+		key string
+		dst *int64
+	}{
+		{"date", &p.PublishAt},
+		{"publish", &p.PublishAt},
+		{"expires", &p.ExpireAt},
+	} {
+		if value, ok := fields[f.key]; ok {
+			ms, err := parseDate(value)
+			if err != nil {
+				return fmt.Errorf("front matter %s: %v", f.key, err)
+			}
+			*f.dst = ms
+		}
+	}
+	return nil
+}
+
+// parseDate parses a front matter date, in the local time zone unless it
+// has one, into milliseconds.
+func parseDate(s string) (int64, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+	}
+	return 0, fmt.Errorf("can't parse date %q", s)
+}
+
+// publishTime returns when a note is to be published according to its
+// attributes: its subject date, or else its reminder time.
+func publishTime(attrs *types.NoteAttributes) int64 {
+	if attrs == nil {
+		return 0
+	}
+	if attrs.IsSetSubjectDate() {
+		return int64(attrs.GetSubjectDate())
+	}
+	return int64(attrs.GetReminderTime())
+}
+
+// publishedPosts returns the posts which are on the blog.
+func publishedPosts(posts map[string]Post) map[string]Post {
+	res := make(map[string]Post, len(posts))
+	for guid, p := range posts {
+		if p.Published {
+			res[guid] = p
+		}
+	}
+	return res
+}
+
+// metaPosts returns the posts as meta.json records them: those which are
+// not on the blog only with what tells when they change or go live, so
+// that the title, tags and notebook of scheduled and expired posts aren't
+// published along with it. Their notebook guid stays for Sync to scope
+// them by.
+func metaPosts(posts map[string]Post) map[string]Post {
+	res := make(map[string]Post, len(posts))
+	for guid, p := range posts {
+		if !p.Published {
+			p = Post{
+				GUID:         p.GUID,
+				Update:       p.Update,
+				NotebookGUID: p.NotebookGUID,
+				PublishAt:    p.PublishAt,
+				ExpireAt:     p.ExpireAt,
+			}
+		}
+		res[guid] = p
+	}
+	return res
+}
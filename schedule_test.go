@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	start := time.Date(2018, 8, 10, 0, 0, 0, 0, time.Local)
+	ms := func(d time.Duration) int64 { return start.Add(d).UnixNano() / int64(time.Millisecond) }
+	src := newMemorySource()
+	src.Add(Post{GUID: "now", Title: "now", Update: 1, Content: helloENML})
+	src.Add(Post{GUID: "later", Title: "later", Update: 2, PublishAt: ms(time.Hour), Content: helloENML})
+	src.Add(Post{GUID: "brief", Title: "brief", Update: 3, Content: `<en-note><div>---</div><div>expires: 2018-08-10 02:00</div><div>---</div><div>soon gone</div></en-note>`})
+	cfg := &Config{}
+	s := newTestSite(t, src, cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	build := func(d time.Duration) map[string]Post {
+		s.now = func() time.Time { return start.Add(d) }
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+		return readMeta(cfg.ReleaseDir)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(cfg.ReleaseDir, name+".html"))
+		return err == nil
+	}
+
+	posts := build(0)
+	if posts["later"].Published || !posts["now"].Published || !posts["brief"].Published {
+		t.Errorf("got posts %v, want all but later published", posts)
+	}
+	if exists("later") || !exists("brief") {
+		t.Error("got the page of later or missing the one of brief")
+	}
+	if index, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "index.html")); strings.Contains(string(index), "later") {
+		t.Error("index lists later before it is due")
+	}
+	if posts["brief"].ExpireAt != ms(2*time.Hour) {
+		t.Errorf("brief expires at %d, want the front matter's time", posts["brief"].ExpireAt)
+	}
+
+	posts = build(90 * time.Minute)
+	if !posts["later"].Published || !exists("later") {
+		t.Error("later not published once due")
+	}
+
+	os.Remove(s.marker)
+	posts = build(3 * time.Hour)
+	if posts["brief"].Published || exists("brief") {
+		t.Error("brief still published after it expired")
+	}
+	if _, err := os.Stat(s.marker); err != nil {
+		t.Error("expiry didn't mark the site changed")
+	}
+}
+
+func TestBuildScheduleKeptPrivate(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	due := time.Now().Add(time.Hour)
+	subject := types.Timestamp(due.UnixNano() / int64(time.Millisecond))
+	f.store.addTag("tag-plans", "plans")
+	f.store.putNote("nb-blog", "note-4", "secret plans", helloENML).Attributes = &types.NoteAttributes{SubjectDate: &subject}
+	f.store.tagNote("note-4", "tag-plans")
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "meta.json"))
+	if strings.Contains(string(meta), "plans") {
+		t.Errorf("meta.json shows the scheduled post:\n%s", meta)
+	}
+	if p := readMeta(cfg.ReleaseDir)["note-4"]; p.PublishAt != int64(subject) || p.Published {
+		t.Errorf("got %v in meta.json, want the schedule of note-4", p)
+	}
+
+	// the post is looked up again once due, as meta.json doesn't tell
+	s = rebuild(f.client(cfg), cfg)
+	s.now = func() time.Time { return due.Add(time.Minute) }
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "secret plans.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "<title>secret plans</title>") {
+		t.Errorf("page lacks the title of the post:\n%s", page)
+	}
+	if p := readMeta(cfg.ReleaseDir)["note-4"]; p.Title != "secret plans" || len(p.Tags) != 1 || !p.Published {
+		t.Errorf("got %v in meta.json, want the published post", p)
+	}
+}
+
+func TestListPostsPublishTime(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	subject := types.Timestamp(1534000000000)
+	f.store.putNote("nb-blog", "note-1", "hello world", helloENML).Attributes = &types.NoteAttributes{SubjectDate: &subject}
+	cfg := testConfig()
+	posts, err := f.client(cfg).ListPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := posts["note-1"]; p.PublishAt != 1534000000000 {
+		t.Errorf("got publish time %d, want the subject date", p.PublishAt)
+	}
+}
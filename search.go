@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// searchEntry is a post in search.json. Terms are the distinct search terms
+// of its title and text, separated by spaces.
+type searchEntry struct {
+	GUID  string `json:"guid"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Terms string `json:"terms"`
+}
+
+// indexText records the search terms of a post. The terms of a post whose
+// page was kept lack the text recognised in its images, so they only serve
+// when the previous index misses the post.
+func (s *Site) indexText(post Post, text string, kept bool) {
+	terms := strings.Join(utils.Terms(post.Title+"\n"+text), " ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kept {
+		s.kept[post.GUID] = terms
+	} else {
+		s.terms[post.GUID] = terms
+	}
+}
+
+func readSearchIndex(dir string) map[string]searchEntry {
+	index := make(map[string]searchEntry)
+	buf, err := ioutil.ReadFile(path.Join(dir, "search.json"))
+	if err != nil {
+		return index
+	}
+	var entries []searchEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		log.Println(err)
+		return index
+	}
+	for _, e := range entries {
+		index[e.GUID] = e
+	}
+	return index
+}
+
+// WriteSearch writes search.json for the published posts, taking the
+// terms of the posts not written in this build from the previous index, and
+// the search page.
+func (s *Site) WriteSearch(posts map[string]Post) error {
+	prev := readSearchIndex(s.cfg.ReleaseDir)
+	entries := make([]searchEntry, 0, len(posts))
+	for _, p := range sortPosts(posts) {
+		terms, ok := s.terms[p.GUID]
+		if e, found := prev[p.GUID]; !ok && found {
+			terms = e.Terms
+		} else if !ok {
+			terms = s.kept[p.GUID]
+		}
+		entries = append(entries, searchEntry{GUID: p.GUID, Title: p.Title, URL: p.link(), Terms: terms})
+	}
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := writeContent(s.cfg.ReleaseDir, "search", "json", string(buf)); err != nil {
+		return err
+	}
+	page, err := ioutil.ReadFile("template/search.html")
+	if err != nil {
+		log.Println("no search page:", err)
+		return nil
+	}
+	return writeContent(s.cfg.ReleaseDir, "search", "html", string(page))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildSearch(t *testing.T) {
+	src := newMemorySource()
+	src.Add(Post{GUID: "zh", Title: "天气", Update: 1, Content: `<en-note><div>今天天气很好</div></en-note>`})
+	src.Add(Post{GUID: "en", Title: "Hello World", Update: 2, Content: helloENML})
+	cfg := &Config{}
+	s := newTestSite(t, src, cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	read := func() map[string]searchEntry {
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "search.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []searchEntry
+		if err := json.Unmarshal(buf, &entries); err != nil {
+			t.Fatal(err)
+		}
+		index := make(map[string]searchEntry)
+		for _, e := range entries {
+			index[e.GUID] = e
+		}
+		return index
+	}
+
+	index := read()
+	if want := "天气 今天 天天 气很 很好"; index["zh"].Terms != want {
+		t.Errorf("zh has terms %q, want %q", index["zh"].Terms, want)
+	}
+	if want := "hello world first post"; index["en"].Terms != want {
+		t.Errorf("en has terms %q, want %q", index["en"].Terms, want)
+	}
+	if index["en"].URL != "Hello%20World.html" {
+		t.Errorf("en links to %q", index["en"].URL)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "search.html")); err != nil {
+		t.Error("no search page:", err)
+	}
+
+	src.Add(Post{GUID: "en", Title: "Hello World", Update: 3, Content: `<en-note><div>second draft</div></en-note>`})
+	s = rebuild(src, cfg)
+	index = read()
+	if !strings.Contains(index["en"].Terms, "draft") {
+		t.Errorf("en has terms %q after the edit", index["en"].Terms)
+	}
+	if index["zh"].Terms == "" {
+		t.Error("lost the terms of the unchanged post")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+	"github.com/dreampuf/evernote-sdk-golang/userstore"
+)
+
+const (
+	defaultHTTPTimeout = 60 * time.Second
+	// tokenWarning is how long before its expiry a token starts being
+	// reported.
+	tokenWarning = 7 * 24 * time.Hour
+)
+
+// session is the connection of a Client to the NoteStore of an account. It
+// resolves the NoteStore URL once and keeps the NoteStore clients of
+// finished calls for reuse. Thrift clients are not safe for concurrent use,
+// so each call takes one out of the pool and only gives it back when the
+// call succeeded.
+type session struct {
+	token        string
+	expiry       time.Time
+	userStoreURL string
+	http         *http.Client
+
+	mu           sync.Mutex
+	noteStoreURL string
+	idle         []*notestore.NoteStoreClient
+}
+
+func newSession(token string, expiry time.Time, userStoreURL string, hc *http.Client) *session {
+	return &session{token: token, expiry: expiry, userStoreURL: userStoreURL, http: hc}
+}
+
+// newLinkedSession returns a session for the NoteStore of a linked
+// notebook, whose URL is known up front. Its token is set once the share is
+// authenticated.
+func newLinkedSession(noteStoreURL string, hc *http.Client) *session {
+	return &session{noteStoreURL: noteStoreURL, http: hc}
+}
+
+// newHTTPClient returns the HTTP client used when none is given, honouring
+// the configured proxy and timeout.
+func newHTTPClient(cfg *Config) (*http.Client, error) {
+	timeout := defaultHTTPTimeout
+	if cfg.HTTPTimeout > 0 {
+		timeout = time.Duration(cfg.HTTPTimeout) * time.Second
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: proxy},
+	}, nil
+}
+
+// get returns an idle NoteStore client or a new one, resolving the
+// NoteStore URL on first use.
+func (s *session) get() (*notestore.NoteStoreClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.idle); n > 0 {
+		store := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		return store, nil
+	}
+	if s.noteStoreURL == "" {
+		if err := checkToken(s.expiry, time.Now()); err != nil {
+			return nil, err
+		}
+		trans, err := thrift.NewTHttpPostClientWithOptions(s.userStoreURL, thrift.THttpClientOptions{Client: s.http})
+		if err != nil {
+			return nil, err
+		}
+		us := userstore.NewUserStoreClientFactory(trans, thrift.NewTBinaryProtocolFactoryDefault())
+		u, err := us.GetNoteStoreUrl(s.token)
+		if err != nil {
+			return nil, err
+		}
+		s.noteStoreURL = u
+	}
+	trans, err := thrift.NewTHttpPostClientWithOptions(s.noteStoreURL, thrift.THttpClientOptions{Client: s.http})
+	if err != nil {
+		return nil, err
+	}
+	return notestore.NewNoteStoreClientFactory(trans, thrift.NewTBinaryProtocolFactoryDefault()), nil
+}
+
+// put gives back the client of a successful call.
+func (s *session) put(store *notestore.NoteStoreClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle = append(s.idle, store)
+}
+
+// tokenExpiry returns the expiry time encoded in the token's "E" field, a
+// hex timestamp in milliseconds.
+func tokenExpiry(token string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(tokenField(token, "E"), 16, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// checkToken fails once the token expired and warns when it is about to.
+// A zero expiry is never checked.
+func checkToken(expiry, now time.Time) error {
+	if expiry.IsZero() {
+		return nil
+	}
+	if !now.Before(expiry) {
+		return fmt.Errorf("token expired at %v, create a new one", expiry.Format(time.RFC3339))
+	}
+	if left := expiry.Sub(now); left < tokenWarning {
+		log.Printf("warning: token expires in %v, at %v", left.Truncate(time.Minute), expiry.Format(time.RFC3339))
+	}
+	return nil
+}
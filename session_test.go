@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tokenExpiring(t time.Time) string {
+	return fmt.Sprintf("S=s1:U=1f:E=%x:C=1:P=1cd:A=en-devtoken:V=2:H=0", t.UnixNano()/int64(time.Millisecond))
+}
+
+func TestTokenExpiry(t *testing.T) {
+	expiry := time.Date(2019, 8, 17, 0, 0, 0, 0, time.UTC)
+	got, ok := tokenExpiry(tokenExpiring(expiry))
+	if !ok || !got.Equal(expiry) {
+		t.Errorf("tokenExpiry() = %v %v, want %v", got, ok, expiry)
+	}
+	if _, ok := tokenExpiry("not-a-developer-token"); ok {
+		t.Error("expiry found in a token without one")
+	}
+	if err := checkToken(expiry, expiry.Add(-time.Hour)); err != nil {
+		t.Errorf("token about to expire rejected: %v", err)
+	}
+	if err := checkToken(expiry, expiry); err == nil {
+		t.Error("expired token accepted")
+	}
+	cfg := &Config{EvernoteToken: "oauth-token-without-fields", EvernoteTokenExpires: expiry.UnixNano() / int64(time.Millisecond)}
+	if got := cfg.TokenExpiry(); !got.Equal(expiry) {
+		t.Errorf("TokenExpiry() = %v, want the stored %v", got, expiry)
+	}
+}
+
+func TestSessionResolvesNoteStoreOnce(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if f.users.calls != 1 {
+		t.Errorf("NoteStore URL resolved %d times, want once", f.users.calls)
+	}
+}
+
+func TestSessionRejectsExpiredToken(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	cfg.EvernoteToken = tokenExpiring(time.Now().Add(-time.Hour))
+	c := f.client(cfg)
+	c.sleep = func(time.Duration) {}
+	if _, err := c.ListPosts(); err == nil {
+		t.Fatal("listed posts with an expired token")
+	}
+	if f.users.calls != 0 {
+		t.Error("expired token sent to the service")
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zhaojkun/yinxiangblog/utils"
+)
+
+// Site renders the posts of a PostSource into the release dir.
+type Site struct {
+	cfg *Config
+	src PostSource
+	// marker is created when the site changed, telling the deploy script
+	// to publish.
+	marker string
+	// sem bounds the number of concurrent downloads from src.
+	sem chan struct{}
+	// now returns the build time; tests replace it.
+	now func() time.Time
+
+	mu sync.Mutex
+	// terms are the search terms of the posts written in this build and
+	// kept those of the posts whose page was already up to date.
+	terms, kept map[string]string
+	// similar finds related posts among those on the blog.
+	similar *similarity
+	// manifest are the files of every post in the previous build and
+	// outputs those written in this one.
+	manifest, outputs map[string]manifestEntry
+}
+
+const defaultConcurrency = 4
+
+func newSite(cfg *Config, src PostSource) *Site {
+	n := cfg.Concurrency
+	if n <= 0 {
+		n = defaultConcurrency
+	}
+	return &Site{
+		cfg:     cfg,
+		src:     src,
+		marker:  "changed.data",
+		sem:     make(chan struct{}, n),
+		now:     time.Now,
+		terms:   make(map[string]string),
+		kept:    make(map[string]string),
+		outputs: make(map[string]manifestEntry),
+	}
+}
+
+// download runs fn, a call to src, once a download slot is free.
+func (s *Site) download(fn func() error) error {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	return fn()
+}
+
+// nowMillis returns the build time in milliseconds.
+func (s *Site) nowMillis() int64 {
+	return s.now().UnixNano() / int64(time.Millisecond)
+}
+
+// Build lists the posts and renders the ones changed since the previous
+// build found in the release dir, restored from the published branch when
+// missing, or all of them when there is none, along with the scheduled
+// posts that became due or expired since.
+func (s *Site) Build() error {
+	s.restoreRelease()
+	prev := readMeta(s.cfg.ReleaseDir)
+	state := readSyncState(s.cfg.ReleaseDir)
+	var posts map[string]Post
+	var changed map[string]bool
+	var err error
+	if syncer, ok := s.src.(Syncer); ok {
+		posts, changed, err = syncer.Sync(prev, state)
+	} else {
+		posts, err = s.src.ListPosts()
+		changed = diffPosts(prev, posts)
+	}
+	if err != nil {
+		return err
+	}
+	for guid, p := range posts {
+		posts[guid] = s.cfg.scrubPost(p)
+	}
+	now := s.nowMillis()
+	for guid, p := range posts {
+		old, ok := prev[guid]
+		if !ok {
+			continue
+		}
+		if p.Slug == "" {
+			p.Slug = old.Slug
+			posts[guid] = p
+		}
+		if changed[guid] {
+			continue
+		}
+		// the schedule of an unchanged post may come from its front
+		// matter, which is only read when the post is written.
+		p.PublishAt, p.ExpireAt, p.Published = old.PublishAt, old.ExpireAt, old.Published
+		posts[guid] = p
+		if p.Published != p.visible(now) {
+			changed[guid] = true
+		}
+	}
+	if prev == nil {
+		if !s.CheckMeta(posts) {
+			log.Println("remote posts equal with meta json")
+			return nil
+		}
+	} else if len(changed) == 0 {
+		// the cursors still moved past the changes to other notes
+		log.Println("no notes changed since last sync")
+		return s.WriteSyncState(state)
+	}
+	log.Println("start to generate htmls,", len(changed), "posts changed")
+	if err := s.fetchPosts(posts, changed); err != nil {
+		return err
+	}
+	visible := make(map[string]Post)
+	for guid, p := range posts {
+		if p.visible(now) {
+			visible[guid] = p
+		}
+	}
+	s.similar = newSimilarity(visible, readSearchIndex(s.cfg.ReleaseDir))
+	s.manifest = readManifest(s.cfg.ReleaseDir, prev)
+	selected := selectPosts(posts, changed)
+	if err := s.WritePosts(selected); err != nil {
+		return err
+	}
+	for guid, p := range selected {
+		posts[guid] = p
+	}
+	if prev != nil && samePosts(prev, metaPosts(posts)) {
+		// e.g. the notes only changed by recording their publish state
+		log.Println("no posts changed since last publish")
+		return s.WriteSyncState(state)
+	}
+	if err := s.WriteIndex(publishedPosts(posts)); err != nil {
+		return err
+	}
+	if err := s.WriteSearch(publishedPosts(posts)); err != nil {
+		return err
+	}
+	if err := s.WriteHighlightCSS(); err != nil {
+		return err
+	}
+	if err := s.WriteManifest(posts); err != nil {
+		return err
+	}
+	if err := s.WriteMeta(metaPosts(posts)); err != nil {
+		return err
+	}
+	if err := s.WriteSyncState(state); err != nil {
+		return err
+	}
+	// only mark the site as changed once everything was written, so that a
+	// failed build never gets half published.
+	return ioutil.WriteFile(s.marker, []byte("true"), 0644)
+}
+
+// fetchPosts looks up the changed posts the previous build only kept the
+// schedule of, see metaPosts, as they are to be written now.
+func (s *Site) fetchPosts(posts map[string]Post, changed map[string]bool) error {
+	fetcher, ok := s.src.(PostFetcher)
+	if !ok {
+		return nil
+	}
+	for guid := range changed {
+		p, ok := posts[guid]
+		if !ok || p.Title != "" {
+			continue
+		}
+		var full Post
+		err := s.download(func() (err error) {
+			full, err = fetcher.FetchPost(guid)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		full = s.cfg.scrubPost(full)
+		full.Slug, full.PublishAt, full.ExpireAt, full.Published = p.Slug, p.PublishAt, p.ExpireAt, p.Published
+		posts[guid] = full
+	}
+	return nil
+}
+
+func (s *Site) CheckMeta(posts map[string]Post) bool {
+	project := s.cfg.ReleaseProject
+	username := s.cfg.ReleaseUserName
+	branch := s.cfg.ReleaseBranch
+	if project == "" || username == "" {
+		return true
+	}
+	metafile := fmt.Sprintf(releaseMeta, username, project, branch)
+	log.Println(metafile)
+	resp, err := http.Get(metafile)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	log.Println(resp.Status)
+	if resp.StatusCode == 404 {
+		return true
+	}
+	if resp.StatusCode != 200 {
+		return false
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true
+	}
+	var respM map[string]Post
+	err = json.Unmarshal(buf, &respM)
+	if err != nil {
+		return true
+	}
+	if len(respM) != len(posts) {
+		return true
+	}
+	now := s.nowMillis()
+	for key, p := range posts {
+		remoteP := respM[key]
+		if p.Update != remoteP.Update || remoteP.Published != remoteP.visible(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePosts renders posts with up to Config.Concurrency workers and
+// updates their schedule and Published flag in posts. When several posts
+// fail, the error of the first one in index order is returned, whatever
+// order the workers ran in.
+func (s *Site) WritePosts(posts map[string]Post) error {
+	list := sortPosts(posts)
+	errs := make([]error, len(list))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(s.sem); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				list[i], errs[i] = s.writePost(list[i])
+			}
+		}()
+	}
+	for i := range list {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range list {
+		posts[p.GUID] = p
+	}
+	return nil
+}
+
+// writePost renders a post if it is on the blog at the build; the pages of
+// the others are removed along with the manifest. It returns the post with
+// its slug and the schedule of its front matter. A post whose title and
+// content are those it was last published with, according to the state
+// recorded in its note, is not rendered again.
+func (s *Site) writePost(post Post) (Post, error) {
+	log.Println(post)
+	old := post.Slug
+	var content string
+	err := s.download(func() (err error) {
+		content, err = s.src.FetchContent(post.GUID)
+		return err
+	})
+	if err != nil {
+		return post, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	// only written back notes hold a state, and reading it costs a call
+	// per post.
+	var ss StateSource
+	if s.cfg.WriteBack {
+		ss, _ = s.src.(StateSource)
+	}
+	var state *PublishState
+	if ss != nil {
+		err := s.download(func() (err error) {
+			state, err = ss.ReadState(post.GUID)
+			return err
+		})
+		if err != nil {
+			return post, fmt.Errorf("post %q: %v", post.Title, err)
+		}
+	}
+	if state != nil && state.Slug != "" {
+		post.Slug = state.Slug
+	}
+	if post.Slug == "" {
+		post.Slug = slugify(post.Title)
+	}
+	fields, body, err := utils.FrontMatter(content)
+	if err != nil {
+		return post, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	if err := post.schedule(fields); err != nil {
+		return post, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	if slug := fields["slug"]; slug != "" {
+		post.Slug = slugify(slug)
+	}
+	post.Published = post.visible(s.nowMillis())
+	if !post.Published {
+		log.Println("post", post.Title, "is not published at the moment")
+		if state != nil && state.URL != "" {
+			offline := *state
+			offline.URL = ""
+			return post, s.writeState(ss, post, &offline)
+		}
+		return post, nil
+	}
+	text, err := utils.Text(body)
+	if err != nil {
+		return post, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	sum := md5.Sum([]byte(post.Title + "\x00" + content))
+	hash := hex.EncodeToString(sum[:])
+	if state != nil && state.Hash == hash && state.Slug == post.Slug && state.URL != "" {
+		if _, err := os.Stat(path.Join(s.cfg.ReleaseDir, post.Slug+".html")); err == nil {
+			log.Println("post", post.Title, "unchanged since it was published")
+			s.indexText(post, text, true)
+			return post, nil
+		}
+	}
+	var history string
+	if vs, ok := s.src.(VersionSource); ok && s.cfg.History > 0 {
+		if history, err = s.writeHistory(vs, post, body); err != nil {
+			return post, fmt.Errorf("post %q: %v", post.Title, err)
+		}
+	}
+	rendered, recognised, assets, err := s.render(post, body)
+	if err != nil {
+		return post, fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	s.indexText(post, text+"\n"+recognised, false)
+	related, err := s.relatedPosts(post)
+	if err != nil {
+		return post, err
+	}
+	contentWithTpl := addTpl(post, rendered, history, related)
+	if err := writeContent(s.cfg.ReleaseDir, post.Slug, "html", contentWithTpl); err != nil {
+		return post, err
+	}
+	if err := s.recordOutput(post, old, history != "", assets); err != nil {
+		return post, err
+	}
+	published := &PublishState{URL: s.permalink(post), Slug: post.Slug, Published: s.nowMillis(), Hash: hash}
+	if state != nil && state.URL == published.URL && state.Slug == published.Slug && state.Hash == published.Hash {
+		return post, nil
+	}
+	return post, s.writeState(ss, post, published)
+}
+
+// writeState records the publish state of a post in its note, when the
+// source can and write back is enabled.
+func (s *Site) writeState(ss StateSource, post Post, state *PublishState) error {
+	if ss == nil || !s.cfg.WriteBack {
+		return nil
+	}
+	err := s.download(func() error {
+		return ss.WriteState(post.GUID, state)
+	})
+	if err != nil {
+		return fmt.Errorf("post %q: %v", post.Title, err)
+	}
+	return nil
+}
+
+// permalink returns the address of the page of a post.
+func (s *Site) permalink(post Post) string {
+	return s.cfg.SiteURL() + post.link()
+}
+
+func (s *Site) WriteMeta(posts map[string]Post) error {
+	buf, err := json.Marshal(posts)
+	if err != nil {
+		return err
+	}
+	return writeContent(s.cfg.ReleaseDir, "meta", "json", string(buf))
+}
+
+func (s *Site) WriteIndex(posts map[string]Post) error {
+	index := generateIndex(posts)
+	return writeContent(s.cfg.ReleaseDir, "index", "html", index)
+}
+
+// WriteHighlightCSS writes the style sheet colouring the code of posts.
+func (s *Site) WriteHighlightCSS() error {
+	style := s.cfg.HighlightStyle
+	if style == "" {
+		style = defaultHighlightStyle
+	}
+	css, err := utils.HighlightCSS(style)
+	if err != nil {
+		return err
+	}
+	return writeContent(s.cfg.ReleaseDir, "highlight", "css", css)
+}
+
+// sortPosts returns the posts newest first.
+func sortPosts(m map[string]Post) []Post {
+	posts := make([]Post, 0, len(m))
+	for _, p := range m {
+		posts = append(posts, p)
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].Update != posts[j].Update {
+			return posts[i].Update > posts[j].Update
+		}
+		return posts[i].GUID < posts[j].GUID
+	})
+	return posts
+}
+
+func generateIndex(m map[string]Post) string {
+	posts := sortPosts(m)
+	tpl, err := template.ParseFiles("template/index.html")
+	if err != nil {
+		var content string
+		for _, p := range posts {
+			link := fmt.Sprintf("<li><a href=\"%s\">%s</a></li>", p.link(), p.Title)
+			content += link
+		}
+		content += fmt.Sprintf("last updated @%v", time.Now())
+		return content
+	}
+	data := make([]map[string]string, 0, len(posts))
+	for _, p := range posts {
+		data = append(data, map[string]string{
+			"Link":     p.link(),
+			"Title":    p.Title,
+			"Notebook": p.Notebook,
+		})
+	}
+	var buf bytes.Buffer
+	tpl.Execute(&buf, data)
+	return buf.String()
+}
+
+// addTpl wraps a post in the post template. history is the link to its
+// history page, if any, and related are the posts to list below it.
+func addTpl(post Post, content, history string, related []Post) string {
+	tpl, err := template.ParseFiles("template/post.html")
+	if err == nil {
+		links := make([]map[string]string, 0, len(related))
+		for _, p := range related {
+			links = append(links, map[string]string{
+				"Link":     p.link(),
+				"Title":    p.Title,
+				"Notebook": p.Notebook,
+			})
+		}
+		var buf bytes.Buffer
+		tpl.Execute(&buf, map[string]interface{}{
+			"Title":    post.Title,
+			"Content":  template.HTML(content),
+			"History":  history,
+			"Related":  links,
+			"Author":   post.Author,
+			"Location": post.Location,
+		})
+		content = buf.String()
+	}
+	return content
+}
+
+// slugify turns a title into the name of a page, keeping it as it is
+// unless it contains path separators or starts with a dot.
+func slugify(title string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimLeft(title, "."))
+	if slug == "" {
+		slug = "untitled"
+	}
+	return slug
+}
+
+// link returns the relative address of the page of a post.
+func (p Post) link() string {
+	slug := p.Slug
+	if slug == "" {
+		slug = slugify(p.Title)
+	}
+	return (&url.URL{Path: slug + ".html"}).String()
+}
+
+// samePosts tells whether two post lists are identical.
+func samePosts(a, b map[string]Post) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for guid, p := range a {
+		if q, ok := b[guid]; !ok || !reflect.DeepEqual(p, q) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeContent(dir, title, ext, content string) error {
+	os.MkdirAll(dir, 0755)
+	p := path.Join(dir, title+"."+ext)
+	return ioutil.WriteFile(p, []byte(content), 0755)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	edam "github.com/dreampuf/evernote-sdk-golang/errors"
+	"github.com/dreampuf/evernote-sdk-golang/types"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+const (
+	helloENML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>first post</div></en-note>`
+	picENML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>here is a image</div><div><en-media hash="2b40b9355fdeec3aa717675b01e6d28d" type="image/png"></en-media></div></en-note>`
+	// picPNG is the image of picENML, a grey pixel.
+	picPNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x00\x00\x00\x00:~\x9bU\x00\x00\x00\nIDATx\x9cch\x00\x00\x00\x82\x00\x81w\xcdr\xb6\x00\x00\x00\x00IEND\xaeB`\x82"
+)
+
+// newFixture serves a "Blog" notebook with two posts, one with an image.
+func newFixture() *fakeEvernote {
+	f := newFakeEvernote()
+	f.store.addNotebook("nb-blog", "Blog", "")
+	f.store.addNotebook("nb-other", "Other", "")
+	f.store.putNote("nb-blog", "note-1", "hello world", helloENML)
+	f.store.putNote("nb-blog", "note-2", "test pic", picENML, fakeResource("image/png", []byte(picPNG)))
+	f.store.putNote("nb-other", "note-3", "not a post", helloENML)
+	return f
+}
+
+// newTestSite returns a site building into a fresh temp dir, which the
+// caller removes.
+func newTestSite(t *testing.T, src PostSource, cfg *Config) *Site {
+	dir, err := ioutil.TempDir("", "yinxiangblog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReleaseDir = filepath.Join(dir, "public")
+	s := newSite(cfg, src)
+	s.marker = filepath.Join(dir, "changed.data")
+	return s
+}
+
+// rebuild returns a new site for the next build of the one newTestSite
+// made for cfg, starting out like a fresh run of the program.
+func rebuild(src PostSource, cfg *Config) *Site {
+	s := newSite(cfg, src)
+	s.marker = filepath.Join(filepath.Dir(cfg.ReleaseDir), "changed.data")
+	return s
+}
+
+func testConfig() *Config {
+	return &Config{EvernoteToken: "S=s1:U=1f:E=0:P=1", EvernoteGUID: "nb-blog"}
+}
+
+// assertGolden compares every file in dir with testdata/golden/name, or
+// rewrites the golden files with -update.
+func assertGolden(t *testing.T, dir, name string) {
+	golden := filepath.Join("testdata", "golden", name)
+	if *update {
+		os.RemoveAll(golden)
+	}
+	seen := make(map[string]bool)
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		seen[rel] = true
+		got, _ := ioutil.ReadFile(p)
+		want := filepath.Join(golden, rel)
+		if *update {
+			os.MkdirAll(filepath.Dir(want), 0755)
+			return ioutil.WriteFile(want, got, 0644)
+		}
+		buf, err := ioutil.ReadFile(want)
+		if err != nil {
+			t.Errorf("unexpected output %s", rel)
+			return nil
+		}
+		if !bytes.Equal(got, buf) {
+			t.Errorf("%s differs from %s:\n%s", rel, want, got)
+		}
+		return nil
+	})
+	filepath.Walk(golden, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(golden, p)
+		if !seen[rel] {
+			t.Errorf("missing output %s", rel)
+		}
+		return nil
+	})
+}
+
+func TestBuildGolden(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.marker); err != nil {
+		t.Error("changed marker not written")
+	}
+	assertGolden(t, cfg.ReleaseDir, "build")
+}
+
+func TestBuildIncremental(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.store.putNote("nb-blog", "note-1", "hello world", helloENML)
+	f.store.putNote("nb-other", "note-3", "not a post", helloENML)
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("GetNote note-1"); n != 2 {
+		t.Errorf("note-1 fetched %d times, want 2", n)
+	}
+	if n := f.store.count("GetNote note-2"); n != 1 {
+		t.Errorf("unchanged note-2 fetched %d times, want 1", n)
+	}
+	if n := f.store.count("FindNotesMetadata"); n != 1 {
+		t.Errorf("notebook listed %d times, want only on the first build", n)
+	}
+
+	f.store.trashNote("note-2")
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	posts := readMeta(cfg.ReleaseDir)
+	if _, ok := posts["note-2"]; ok || len(posts) != 1 {
+		t.Errorf("trashed note still in meta: %v", posts)
+	}
+
+	// changes to other notebooks are synced once, though no post changed
+	synced := f.store.count("GetFilteredSyncChunk")
+	f.store.putNote("nb-other", "note-3", "still not a post", helloENML)
+	for i := 0; i < 2; i++ {
+		s = rebuild(f.client(cfg), cfg)
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.store.count("GetFilteredSyncChunk") - synced; n != 1 {
+		t.Errorf("synced %d chunks, want only the new one", n)
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>first post, now edited</div></en-note>`)
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>first post, edited twice</div><div>with a new line</div></en-note>`)
+	cfg := testConfig()
+	cfg.History = 5
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, cfg.ReleaseDir, "history")
+	if n := f.store.count("GetNoteVersion note-1"); n != 2 {
+		t.Errorf("fetched %d versions of note-1, want 2", n)
+	}
+
+	cfg.History = 1
+	f.store.putNote("nb-blog", "note-1", "hello world", helloENML)
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.count("GetNoteVersion note-1"); n != 3 {
+		t.Errorf("fetched %d versions of note-1, want only the latest one more", n)
+	}
+
+	// front matter is no change of the text
+	matter := `<en-note><div>---</div><div>slug: hello</div><div>---</div><div>first post</div>`
+	f.store.putNote("nb-blog", "note-1", "hello world", matter+`</en-note>`)
+	f.store.putNote("nb-blog", "note-1", "hello world", matter+`<div>again</div></en-note>`)
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "hello.history.html"))
+	if !strings.Contains(string(page), `<div class="diff">first post<ins>`) || strings.Contains(string(page), "slug") {
+		t.Errorf("history shows the front matter:\n%s", page)
+	}
+}
+
+func TestBuildHistoryNotPremium(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.putNote("nb-blog", "note-1", "hello world", `<en-note><div>first post, now edited</div></en-note>`)
+	f.store.fail("ListNoteVersions", &edam.EDAMUserException{ErrorCode: edam.EDAMErrorCode_PERMISSION_DENIED})
+	cfg := testConfig()
+	cfg.History = 5
+	cfg.Concurrency = 1
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "hello world.html"))
+	if err != nil || strings.Contains(string(page), "History") {
+		t.Errorf("got page %s, %v, want one without history", page, err)
+	}
+	if n := f.store.count("ListNoteVersions note-1") + f.store.count("ListNoteVersions note-2"); n != 1 {
+		t.Errorf("versions listed %d times, want no more after being refused", n)
+	}
+}
+
+func TestBuildRecognition(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	sign := fakeResource("image/png", []byte(picPNG))
+	sign.Recognition = &types.Data{Body: []byte(`<recoIndex><item><t w="40">0PEN</t><t w="90">OPEN</t></item><item><t w="70">DAILY</t></item></recoIndex>`)}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 1))); err != nil {
+		t.Fatal(err)
+	}
+	photo := fakeResource("image/png", buf.Bytes())
+	photo.Recognition = &types.Data{Body: []byte(`<recoIndex><item><t w="50">BLUR</t></item></recoIndex>`)}
+	f.store.putNote("nb-blog", "note-4", "shop", `<en-note><div><en-media hash="`+hex.EncodeToString(sign.Data.BodyHash)+`" type="image/png"/></div>`+
+		`<div><en-media type="image/png" alt="the &quot;shop&quot;" hash="`+hex.EncodeToString(photo.Data.BodyHash)+`"></en-media></div></en-note>`, sign, photo)
+	cfg := testConfig()
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "shop.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`alt="OPEN DAILY"/>`, `alt="the &#34;shop&#34;"/>`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("page lacks %s:\n%s", want, page)
+		}
+	}
+	index, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "search.json"))
+	if !strings.Contains(string(index), "open daily blur") {
+		t.Errorf("recognised text not indexed: %s", index)
+	}
+}
+
+func TestListPostsPaging(t *testing.T) {
+	f := newFakeEvernote()
+	defer f.Close()
+	f.store.addNotebook("nb-blog", "Blog", "Stack")
+	f.store.addNotebook("nb-more", "More", "Stack")
+	f.store.addNotebook("nb-other", "Other", "")
+	for i := 0; i < 150; i++ {
+		guid := string(rune('a'+i/26)) + string(rune('a'+i%26))
+		f.store.putNote("nb-blog", "blog-"+guid, guid, helloENML)
+	}
+	f.store.putNote("nb-more", "more-1", "more", helloENML)
+	f.store.putNote("nb-other", "other-1", "other", helloENML)
+	cfg := &Config{EvernoteStack: "Stack"}
+	posts, err := f.client(cfg).ListPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 151 {
+		t.Fatalf("got %d posts, want 151", len(posts))
+	}
+	if p := posts["more-1"]; p.Notebook != "More" || p.NotebookGUID != "nb-more" {
+		t.Errorf("post tagged with notebook %q (%s)", p.Notebook, p.NotebookGUID)
+	}
+	if _, ok := posts["other-1"]; ok {
+		t.Error("post from a notebook outside the stack listed")
+	}
+}
+
+func TestBuildTagRules(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.addTag("tag-publish", "publish")
+	f.store.addTag("tag-draft", "draft")
+	f.store.addTag("tag-go", "go")
+	f.store.tagNote("note-1", "tag-publish", "tag-go")
+	cfg := testConfig()
+	cfg.PublishTag = "publish"
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	posts := readMeta(cfg.ReleaseDir)
+	if len(posts) != 1 || strings.Join(posts["note-1"].Tags, ",") != "publish,go" {
+		t.Errorf("got posts %v, want only note-1 tagged publish,go", posts)
+	}
+
+	f.store.tagNote("note-1", "tag-publish", "tag-draft")
+	f.store.tagNote("note-2", "tag-publish")
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	posts = readMeta(cfg.ReleaseDir)
+	if _, ok := posts["note-2"]; !ok || len(posts) != 1 {
+		t.Errorf("got posts %v, want only note-2", posts)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "hello world.html")); !os.IsNotExist(err) {
+		t.Error("page of the draft still online")
+	}
+	if n := f.store.count("FindNotesMetadata"); n != 1 {
+		t.Errorf("notebook listed %d times, want only on the first build", n)
+	}
+
+	cfg.PublishTag = "missing"
+	if err := newSite(cfg, f.client(cfg)).Build(); err == nil || !strings.Contains(err.Error(), "tag missing not found") {
+		t.Errorf("got %v, want an error for the missing tag", err)
+	}
+}
+
+func TestListPostsSavedSearch(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.store.addSearch("Pictures", "image")
+	cfg := testConfig()
+	cfg.SavedSearch = "pictures"
+	posts, err := f.client(cfg).ListPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := posts["note-2"]; !ok || len(posts) != 1 {
+		t.Errorf("got posts %v, want only note-2", posts)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ReleaseDir, "hello world.html")); !os.IsNotExist(err) {
+		t.Error("page of the draft still online")
+	}
+}
+
+func TestBuildLinkedNotebook(t *testing.T) {
+	f := newFixture()
+	defer f.Close()
+	f.shared.addNotebook("nb-team", "Team", "")
+	f.shared.putNote("nb-team", "team-1", "team pic", picENML, fakeResource("image/png", []byte(picPNG)))
+	f.share("nb-team", "Team Blog", "")
+	cfg := testConfig()
+	cfg.LinkedNotebooks = []string{"team blog"}
+	s := newTestSite(t, f.client(cfg), cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	posts := readMeta(cfg.ReleaseDir)
+	if p := posts["team-1"]; len(posts) != 3 || p.Notebook != "Team Blog" || p.NotebookGUID != "nb-team" {
+		t.Errorf("got posts %v, want note-1, note-2 and team-1 from Team Blog", posts)
+	}
+	if n := f.shared.count("GetResourceByHash team-1"); n != 1 {
+		t.Errorf("image of team-1 fetched %d times from the shared store, want 1", n)
+	}
+
+	f.shared.putNote("nb-team", "team-1", "team pic", picENML, fakeResource("image/png", []byte(picPNG)))
+	s = rebuild(f.client(cfg), cfg)
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.shared.count("GetNote team-1"); n != 2 {
+		t.Errorf("team-1 fetched %d times, want 2", n)
+	}
+	if n := f.store.count("GetNote note-1"); n != 1 {
+		t.Errorf("unchanged note-1 fetched %d times, want 1", n)
+	}
+	if n := f.shared.count("FindNotesMetadata"); n != 1 {
+		t.Errorf("linked notebook listed %d times, want only on the first build", n)
+	}
+	if state := readSyncState(cfg.ReleaseDir); state["linked:nb-team"].USN != 2 {
+		t.Errorf("got sync state %v, want a cursor for the linked notebook", state)
+	}
+
+	cfg.LinkedNotebooks = []string{"Missing"}
+	if err := newSite(cfg, f.client(cfg)).Build(); err == nil || !strings.Contains(err.Error(), "linked notebook Missing not found") {
+		t.Errorf("got %v, want an error for the missing share", err)
+	}
+}
+
+// slowSource delays downloads and records how many run at once.
+type slowSource struct {
+	PostSource
+	mu          sync.Mutex
+	active, max int
+}
+
+func (s *slowSource) track(fn func()) {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.max {
+		s.max = s.active
+	}
+	s.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	fn()
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+}
+
+func (s *slowSource) FetchContent(guid string) (content string, err error) {
+	s.track(func() { content, err = s.PostSource.FetchContent(guid) })
+	return
+}
+
+func (s *slowSource) FetchResource(guid, hash string) (res *Resource, err error) {
+	s.track(func() { res, err = s.PostSource.FetchResource(guid, hash) })
+	return
+}
+
+func TestWritePostsConcurrency(t *testing.T) {
+	mem := newMemorySource()
+	var resources []*Resource
+	content := `<en-note>`
+	for i := 0; i < 8; i++ {
+		body := []byte{byte(i)}
+		sum := md5.Sum(body)
+		hash := hex.EncodeToString(sum[:])
+		resources = append(resources, &Resource{Hash: hash, Mime: "image/png", Data: body})
+		content += `<div><en-media hash="` + hash + `" type="image/png"></en-media></div>`
+	}
+	content += `</en-note>`
+	for i := 0; i < 6; i++ {
+		guid := fmt.Sprintf("note-%d", i)
+		mem.Add(Post{GUID: guid, Title: guid, Update: int64(i), Content: content}, resources...)
+	}
+	src := &slowSource{PostSource: mem}
+	cfg := &Config{Concurrency: 3}
+	s := newTestSite(t, src, cfg)
+	defer os.RemoveAll(filepath.Dir(cfg.ReleaseDir))
+	posts, _ := mem.ListPosts()
+	if err := s.WritePosts(posts); err != nil {
+		t.Fatal(err)
+	}
+	if src.max > 3 || src.max < 2 {
+		t.Errorf("%d downloads ran at once, want 2 or 3", src.max)
+	}
+	first, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, "note-0.html"))
+	for i := 1; i < 6; i++ {
+		buf, _ := ioutil.ReadFile(filepath.Join(cfg.ReleaseDir, fmt.Sprintf("note-%d.html", i)))
+		if !bytes.Equal(bytes.Replace(buf, []byte(fmt.Sprintf("note-%d", i)), []byte("note-0"), -1), first) {
+			t.Errorf("note-%d.html rendered differently from note-0.html", i)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+)
+
+// PostSource is where the blog posts come from: the Evernote API, an .enex
+// export or memory.
+type PostSource interface {
+	ListPosts() (map[string]Post, error)
+	FetchContent(guid string) (string, error)
+	FetchResource(guid, hash string) (*Resource, error)
+}
+
+// Syncer is implemented by sources that can tell which posts changed since
+// the last build without listing and comparing everything.
+type Syncer interface {
+	Sync(prev map[string]Post, state SyncState) (map[string]Post, map[string]bool, error)
+}
+
+// PostFetcher is implemented by sources that can look up a single post,
+// such as one of those meta.json only keeps the schedule of.
+type PostFetcher interface {
+	FetchPost(guid string) (Post, error)
+}
+
+// VersionSource is implemented by sources that keep the past versions of
+// posts.
+type VersionSource interface {
+	// ListVersions returns the saved versions of a post, oldest first, or
+	// errNoHistory when the source keeps none after all.
+	ListVersions(guid string) ([]Version, error)
+	// FetchVersion returns the ENML of a version.
+	FetchVersion(guid string, usn int32) (string, error)
+}
+
+// StateSource is implemented by sources that can keep the publish state of
+// posts with the notes themselves.
+type StateSource interface {
+	ReadState(guid string) (*PublishState, error)
+	WriteState(guid string, state *PublishState) error
+}
+
+// RelatedSource is implemented by sources that can find the posts related
+// to a post themselves.
+type RelatedSource interface {
+	// FindRelated returns the guids of up to max related posts, or
+	// errNoRelated when the source can't tell after all.
+	FindRelated(post Post, max int) ([]string, error)
+}
+
+// Version identifies a past version of a post.
+type Version struct {
+	USN   int32
+	Saved int64
+	Title string
+}
+
+type Resource struct {
+	Hash string
+	Mime string
+	Data []byte
+	// Text is the text recognised in an image, if any.
+	Text string
+	// FileName is the name of the attached file, if known.
+	FileName string
+	// Width and Height are the size of an image in pixels, if known.
+	Width, Height int
+}
+
+func newSource(cfg *Config) (PostSource, error) {
+	if cfg.EnexPath != "" {
+		src, err := readEnex(cfg.EnexPath)
+		if err != nil {
+			return nil, err
+		}
+		src.keep(func(p Post) bool { return cfg.Publishable(p.Tags) })
+		return src, nil
+	}
+	return newClient(cfg)
+}
+
+// memorySource serves posts held in memory. It backs the enex reader and
+// tests.
+type memorySource struct {
+	posts     map[string]Post
+	resources map[string]map[string]*Resource
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{
+		posts:     make(map[string]Post),
+		resources: make(map[string]map[string]*Resource),
+	}
+}
+
+// Add stores a post, with its ENML in p.Content, and its resources.
+func (m *memorySource) Add(p Post, resources ...*Resource) {
+	m.posts[p.GUID] = p
+	res := make(map[string]*Resource)
+	for _, r := range resources {
+		res[r.Hash] = r
+	}
+	m.resources[p.GUID] = res
+}
+
+// keep drops the posts for which fn returns false.
+func (m *memorySource) keep(fn func(Post) bool) {
+	for guid, p := range m.posts {
+		if !fn(p) {
+			delete(m.posts, guid)
+			delete(m.resources, guid)
+		}
+	}
+}
+
+func (m *memorySource) ListPosts() (map[string]Post, error) {
+	res := make(map[string]Post, len(m.posts))
+	for guid, p := range m.posts {
+		p.Content = ""
+		res[guid] = p
+	}
+	return res, nil
+}
+
+func (m *memorySource) FetchContent(guid string) (string, error) {
+	p, ok := m.posts[guid]
+	if !ok {
+		return "", fmt.Errorf("note %s not found", guid)
+	}
+	return p.Content, nil
+}
+
+func (m *memorySource) FetchResource(guid, hash string) (*Resource, error) {
+	r, ok := m.resources[guid][hash]
+	if !ok {
+		return nil, fmt.Errorf("resource %s of note %s not found", hash, guid)
+	}
+	return r, nil
+}
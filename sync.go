@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/dreampuf/evernote-sdk-golang/notestore"
+)
+
+const syncChunkSize = 100
+
+// SyncCursor is the last UpdateSequenceNum seen for an account, the server
+// time of that sync, used to honour SyncState.FullSyncBefore, and the blog
+// notebooks and publishing rule tags it covered.
+type SyncCursor struct {
+	USN       int32    `json:"usn"`
+	Synced    int64    `json:"synced"`
+	Notebooks []string `json:"notebooks"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// SyncState holds one cursor per account and is persisted as sync.json
+// next to meta.json in the release dir.
+type SyncState map[string]SyncCursor
+
+func readSyncState(dir string) SyncState {
+	state := make(SyncState)
+	buf, err := ioutil.ReadFile(path.Join(dir, "sync.json"))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		log.Println(err)
+	}
+	return state
+}
+
+func (s *Site) WriteSyncState(state SyncState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeContent(s.cfg.ReleaseDir, "sync", "json", string(buf))
+}
+
+// readMeta loads the posts of the previous build from the release dir, or
+// nil when there is no previous build to update incrementally.
+func readMeta(dir string) map[string]Post {
+	buf, err := ioutil.ReadFile(path.Join(dir, "meta.json"))
+	if err != nil {
+		return nil
+	}
+	var posts map[string]Post
+	if err := json.Unmarshal(buf, &posts); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return posts
+}
+
+// tokenField returns a field of a developer or OAuth token, which look like
+// "S=s1:U=8f2:E=16b...:C=...:P=...:A=...:V=2:H=...".
+func tokenField(token, key string) string {
+	for _, field := range strings.Split(token, ":") {
+		if strings.HasPrefix(field, key+"=") {
+			return field[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+func (c *Client) account() string {
+	if u := tokenField(c.token, "U"); u != "" {
+		return u
+	}
+	return "default"
+}
+
+// syncScope is what one cursor covers: the blog notebooks of the account,
+// or a linked notebook, which is synced on its owner's NoteStore.
+type syncScope struct {
+	key       string
+	notebooks []string
+	linked    *linkedNotebook
+}
+
+// scopes returns the sync scopes of the blog notebooks.
+func (c *Client) scopes() []syncScope {
+	var scopes []syncScope
+	var own []string
+	for _, guid := range c.notebookGUIDs() {
+		if _, ok := c.linked[guid]; !ok {
+			own = append(own, guid)
+		}
+	}
+	if len(own) > 0 {
+		scopes = append(scopes, syncScope{key: c.account(), notebooks: own})
+	}
+	for _, guid := range c.linkedGUIDs() {
+		scopes = append(scopes, syncScope{key: "linked:" + guid, notebooks: []string{guid}, linked: c.linked[guid]})
+	}
+	return scopes
+}
+
+func (c *Client) syncState(scope syncScope) (ss *notestore.SyncState, err error) {
+	if ln := scope.linked; ln != nil {
+		err = c.callOn(ln.session, "GetLinkedNotebookSyncState", func(store *notestore.NoteStoreClient) (err error) {
+			ss, err = store.GetLinkedNotebookSyncState(ln.session.token, ln.LinkedNotebook)
+			return err
+		})
+		return ss, err
+	}
+	err = c.call("GetSyncState", func(store *notestore.NoteStoreClient) (err error) {
+		ss, err = store.GetSyncState(c.token)
+		return err
+	})
+	return ss, err
+}
+
+func (c *Client) syncChunk(scope syncScope, afterUSN int32) (chunk *notestore.SyncChunk, err error) {
+	if ln := scope.linked; ln != nil {
+		err = c.callOn(ln.session, "GetLinkedNotebookSyncChunk", func(store *notestore.NoteStoreClient) (err error) {
+			chunk, err = store.GetLinkedNotebookSyncChunk(ln.session.token, ln.LinkedNotebook, afterUSN, syncChunkSize, false)
+			return err
+		})
+		return chunk, err
+	}
+	t := true
+	filter := notestore.SyncChunkFilter{
+		IncludeNotes:          &t,
+		IncludeNoteAttributes: &t,
+		IncludeResources:      &t,
+		IncludeExpunged:       &t,
+	}
+	err = c.call("GetFilteredSyncChunk", func(store *notestore.NoteStoreClient) (err error) {
+		chunk, err = store.GetFilteredSyncChunk(c.token, afterUSN, syncChunkSize, &filter)
+		return err
+	})
+	return chunk, err
+}
+
+// Sync returns the current post list along with the guids of the posts that
+// were added, updated or removed since the cursors stored in state, one for
+// the account and one per linked notebook. When there is no previous build
+// or the server requires it, it falls back to a full listing and diffs it
+// against prev, as it does when the publishing rules changed or a saved
+// search selects the posts. state is updated in place.
+func (c *Client) Sync(prev map[string]Post, state SyncState) (map[string]Post, map[string]bool, error) {
+	if err := c.resolve(); err != nil {
+		return nil, nil, err
+	}
+	posts := make(map[string]Post)
+	changed := make(map[string]bool)
+	keys := make(map[string]bool)
+	for _, scope := range c.scopes() {
+		keys[scope.key] = true
+		scoped, ch, err := c.syncScope(scope, prev, state)
+		if err != nil {
+			return nil, nil, err
+		}
+		for guid, p := range scoped {
+			posts[guid] = p
+		}
+		for guid := range ch {
+			changed[guid] = true
+		}
+	}
+	// posts of notebooks which are no longer on the blog
+	for guid, p := range prev {
+		if _, ok := c.notebooks[p.NotebookGUID]; !ok {
+			changed[guid] = true
+		}
+	}
+	for key := range state {
+		if strings.HasPrefix(key, "linked:") && !keys[key] {
+			delete(state, key)
+		}
+	}
+	c.locate(posts)
+	return posts, changed, nil
+}
+
+// syncScope syncs the posts of one scope, see Sync.
+func (c *Client) syncScope(scope syncScope, prev map[string]Post, state SyncState) (map[string]Post, map[string]bool, error) {
+	ss, err := c.syncState(scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	inScope := make(map[string]bool)
+	for _, guid := range scope.notebooks {
+		inScope[guid] = true
+	}
+	var scoped map[string]Post
+	if prev != nil {
+		scoped = make(map[string]Post)
+		for guid, p := range prev {
+			if inScope[p.NotebookGUID] {
+				scoped[guid] = p
+			}
+		}
+	}
+	cursor := state[scope.key]
+	tags := c.tagGUIDs(append([]string{c.cfg.PublishTag}, c.cfg.hiddenTags()...)...)
+	state[scope.key] = SyncCursor{USN: ss.UpdateCount, Synced: int64(ss.CurrentTime), Notebooks: scope.notebooks, Tags: tags}
+	if prev == nil || cursor.USN == 0 || cursor.Synced < int64(ss.FullSyncBefore) || c.query != "" ||
+		strings.Join(cursor.Notebooks, ",") != strings.Join(scope.notebooks, ",") ||
+		strings.Join(cursor.Tags, ",") != strings.Join(tags, ",") {
+		posts, err := c.listNotebooks(scope.notebooks)
+		if err != nil {
+			return nil, nil, err
+		}
+		return posts, diffPosts(scoped, posts), nil
+	}
+	posts := make(map[string]Post, len(scoped))
+	for guid, p := range scoped {
+		posts[guid] = p
+	}
+	changed := make(map[string]bool)
+	remove := func(guid string) {
+		if _, ok := posts[guid]; ok {
+			delete(posts, guid)
+			changed[guid] = true
+		}
+	}
+	afterUSN := cursor.USN
+	for afterUSN < ss.UpdateCount {
+		chunk, err := c.syncChunk(scope, afterUSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, note := range chunk.GetNotes() {
+			guid := string(note.GetGUID())
+			notebook := note.GetNotebookGuid()
+			inactive := note.IsSetDeleted() || (note.IsSetActive() && !note.GetActive())
+			p := c.newPost(guid, note.GetTitle(), int64(note.GetUpdated()), notebook, note.TagGuids, note.Attributes)
+			if !inScope[notebook] || inactive || !c.cfg.Publishable(p.Tags) {
+				remove(guid)
+				continue
+			}
+			posts[guid] = p
+			changed[guid] = true
+		}
+		for _, res := range chunk.GetResources() {
+			guid := string(res.GetNoteGuid())
+			if _, ok := posts[guid]; ok {
+				changed[guid] = true
+			}
+		}
+		for _, guid := range chunk.GetExpungedNotes() {
+			remove(guid)
+		}
+		for _, notebook := range chunk.GetExpungedNotebooks() {
+			for guid, p := range posts {
+				if p.NotebookGUID == notebook {
+					remove(guid)
+				}
+			}
+		}
+		if !chunk.IsSetChunkHighUSN() {
+			break
+		}
+		afterUSN = chunk.GetChunkHighUSN()
+	}
+	return posts, changed, nil
+}
+
+// diffPosts returns the guids whose post was added, updated or removed
+// between two listings.
+func diffPosts(prev, posts map[string]Post) map[string]bool {
+	changed := make(map[string]bool)
+	for guid, p := range posts {
+		// meta.json keeps no title of the posts which aren't on the blog
+		if old, ok := prev[guid]; !ok || old.Update != p.Update || old.Title != "" && old.Title != p.Title {
+			changed[guid] = true
+		}
+	}
+	for guid := range prev {
+		if _, ok := posts[guid]; !ok {
+			changed[guid] = true
+		}
+	}
+	return changed
+}
+
+func selectPosts(posts map[string]Post, guids map[string]bool) map[string]Post {
+	res := make(map[string]Post)
+	for guid := range guids {
+		if p, ok := posts[guid]; ok {
+			res[guid] = p
+		}
+	}
+	return res
+}
@@ -0,0 +1,13 @@
+module github.com/dreampuf/evernote-sdk-golang
+
+go 1.21
+
+require (
+	github.com/apache/thrift v0.0.0
+	github.com/mrjones/oauth v0.0.0
+)
+
+replace (
+	github.com/apache/thrift => ../thrift
+	github.com/mrjones/oauth => ../oauth
+)
@@ -0,0 +1,3 @@
+module github.com/mrjones/oauth
+
+go 1.21
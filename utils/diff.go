@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+)
+
+var blockElements = map[string]bool{
+	"div": true, "p": true, "br": true, "li": true, "tr": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "hr": true,
+}
+
+// Text returns the text of an ENML note, with a line break after every
+// block element so that the words of adjacent paragraphs stay apart.
+func Text(content string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		if n.Type == nethtml.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == nethtml.ElementNode && blockElements[n.Data] {
+			buf.WriteByte('\n')
+		}
+	}
+	for _, n := range doc.Find("en-note").Nodes {
+		walk(n)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	res := lines[:0]
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			res = append(res, line)
+		}
+	}
+	return strings.Join(res, "\n"), nil
+}
+
+// DiffHTML marks up the words of b against a: words removed from a are
+// wrapped in <del>, words added in b in <ins>. Chinese, Japanese and Korean
+// characters count as one word each, as those scripts don't separate words
+// with spaces. All text is HTML escaped.
+func DiffHTML(a, b string) string {
+	var buf bytes.Buffer
+	var run []string
+	kind := ' '
+	flush := func() {
+		text := html.EscapeString(strings.Join(run, ""))
+		switch kind {
+		case '-':
+			buf.WriteString("<del>" + text + "</del>")
+		case '+':
+			buf.WriteString("<ins>" + text + "</ins>")
+		default:
+			buf.WriteString(text)
+		}
+		run = run[:0]
+	}
+	for _, e := range diffWords(words(a), words(b)) {
+		if e.op != kind {
+			flush()
+			kind = e.op
+		}
+		run = append(run, e.word)
+	}
+	flush()
+	return buf.String()
+}
+
+type edit struct {
+	op   rune
+	word string
+}
+
+// diffWords returns the shortest edit script turning a into b, removals
+// before additions wherever words changed. Past a few hundred words it
+// uses Myers' O(ND) algorithm in linear space, so that long texts, such as
+// Chinese with a word per character, take time and memory after their
+// changes rather than their length squared.
+func diffWords(a, b []string) []edit {
+	var res []edit
+	diffRange(a, b, &res)
+	// put the removals of every changed run before its additions
+	for i := 0; i < len(res); {
+		if res[i].op == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(res) && res[j].op != ' ' {
+			j++
+		}
+		sort.SliceStable(res[i:j], func(x, y int) bool {
+			return res[i+x].op == '-' && res[i+y].op == '+'
+		})
+		i = j
+	}
+	return res
+}
+
+// maxTable is the most cells of the table diffTable fills, 512 KB.
+const maxTable = 1 << 16
+
+// diffRange appends the edit script turning a into b to res, splitting
+// both at the middle snake of the script until they are small enough for
+// diffTable or one side is empty.
+func diffRange(a, b []string, res *[]edit) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*res = append(*res, edit{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	tail := a[len(a)-n:]
+	a, b = a[:len(a)-n], b[:len(b)-n]
+	switch {
+	case len(a) == 0:
+		for _, w := range b {
+			*res = append(*res, edit{'+', w})
+		}
+	case len(b) == 0:
+		for _, w := range a {
+			*res = append(*res, edit{'-', w})
+		}
+	case len(a)*len(b) <= maxTable:
+		diffTable(a, b, res)
+	default:
+		// without a common head or tail, the script has at least two
+		// edits, one on either side of the snake.
+		x, y, u, v := middleSnake(a, b)
+		diffRange(a[:x], b[:y], res)
+		for _, w := range a[x:u] {
+			*res = append(*res, edit{' ', w})
+		}
+		diffRange(a[u:], b[v:], res)
+	}
+	for _, w := range tail {
+		*res = append(*res, edit{' ', w})
+	}
+}
+
+// diffTable appends the edit script turning a into b to res, built from a
+// table of the longest common subsequences of their ends. Where several
+// scripts are as short, it keeps the earlier words of a, which reads
+// better than the middle snake's choice.
+func diffTable(a, b []string, res *[]edit) {
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			*res = append(*res, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			*res = append(*res, edit{'-', a[i]})
+			i++
+		default:
+			*res = append(*res, edit{'+', b[j]})
+			j++
+		}
+	}
+}
+
+// middleSnake returns the diagonal run of common words, from a[x], b[y] to
+// a[u], b[v], in the middle of a shortest edit script turning a into b,
+// searching from both ends at once. It needs memory for as many diagonals
+// as a and b have words.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	// forward[off+k] is how far along a the furthest path from the start
+	// on diagonal k, where x-y = k, got; backward the same from the end,
+	// reading both backwards.
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+			if kb := delta - k; odd && kb >= -(d-1) && kb <= d-1 && x+backward[off+kb] >= n {
+				return x0, y0, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[off+k-1] < backward[off+k+1]) {
+				x = backward[off+k+1]
+			} else {
+				x = backward[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[off+k] = x
+			if kf := delta - k; !odd && kf >= -d && kf <= d && forward[off+kf]+x >= n {
+				return n - x, m - y, n - x0, m - y0
+			}
+		}
+	}
+	panic("utils: no middle snake")
+}
+
+// words splits s into words and the spaces between them.
+func words(s string) []string {
+	var res []string
+	start, class := 0, 0
+	for i, r := range s {
+		c := runeClass(r)
+		if i > start && (c != class || c == classCJK) {
+			res = append(res, s[start:i])
+			start = i
+		}
+		class = c
+	}
+	if start < len(s) {
+		res = append(res, s[start:])
+	}
+	return res
+}
+
+const (
+	classWord = iota
+	classSpace
+	classCJK
+)
+
+func runeClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return classSpace
+	case isCJK(r), unicode.IsPunct(r) && r > unicode.MaxLatin1:
+		return classCJK
+	}
+	return classWord
+}
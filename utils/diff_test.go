@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>first <b>post</b></div><div><br/></div><div>second &amp; last</div></en-note>`
+	res, err := Text(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first post\nsecond & last"; res != want {
+		t.Errorf("Text() = %q, want %q", res, want)
+	}
+}
+
+func TestDiffHTML(t *testing.T) {
+	for _, c := range []struct {
+		a, b, want string
+	}{
+		{"same text", "same text", "same text"},
+		{"", "new <post>", "<ins>new &lt;post&gt;</ins>"},
+		{"the quick fox", "the slow fox", "the <del>quick</del><ins>slow</ins> fox"},
+		{"one two three", "one three", "one <del>two </del>three"},
+		{"今天天气很好", "今天天气不好", "今天天气<del>很</del><ins>不</ins>好"},
+	} {
+		if got := DiffHTML(c.a, c.b); got != c.want {
+			t.Errorf("DiffHTML(%q, %q) = %q, want %q", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestDiffWordsLong(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	text := func(n int) []string {
+		res := make([]string, n)
+		for i := range res {
+			res[i] = string(rune('a' + r.Intn(4)))
+		}
+		return res
+	}
+	for i := 0; i < 20; i++ {
+		a := text(200 + r.Intn(400))
+		b := append([]string(nil), a...)
+		for j := r.Intn(60); j > 0; j-- {
+			k := r.Intn(len(b))
+			switch r.Intn(3) {
+			case 0:
+				b = append(b[:k], b[k+1:]...)
+			case 1:
+				b = append(b[:k], append([]string{"x"}, b[k:]...)...)
+			default:
+				b[k] = "y"
+			}
+		}
+		var from, to []string
+		common := 0
+		for _, e := range diffWords(a, b) {
+			if e.op != '+' {
+				from = append(from, e.word)
+			}
+			if e.op != '-' {
+				to = append(to, e.word)
+			}
+			if e.op == ' ' {
+				common++
+			}
+		}
+		if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+			t.Fatalf("diffWords(%v, %v) doesn't turn one into the other", a, b)
+		}
+		var lcs []edit
+		diffTable(a, b, &lcs)
+		want := 0
+		for _, e := range lcs {
+			if e.op == ' ' {
+				want++
+			}
+		}
+		if common != want {
+			t.Errorf("diffWords() keeps %d words, want %d", common, want)
+		}
+	}
+
+	// a long Chinese post, edited at both ends
+	long := strings.Repeat("今天天气很好我们去公园散步吧", 1000)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	got := DiffHTML(long, "昨天"+long[3*2:len(long)-3]+"呢")
+	runtime.ReadMemStats(&after)
+	if !strings.HasPrefix(got, "<del>今</del><ins>昨</ins>天天气") || !strings.HasSuffix(got, "<del>吧</del><ins>呢</ins>") {
+		t.Errorf("DiffHTML() of a long text = %.40q...", got)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<20 {
+		t.Errorf("DiffHTML() of a long text allocated %d MB", n>>20)
+	}
+}
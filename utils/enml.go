@@ -0,0 +1,290 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Media is an en-media element of a note.
+type Media struct {
+	Hash string
+	Type string
+	// Attrs are all its attributes, e.g. alt, title, width and height.
+	Attrs map[string]string
+}
+
+// IsImage tells whether the media is an image.
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.Type, "image/")
+}
+
+// enmlElements are the XHTML elements allowed in ENML besides the en-*
+// ones.
+var enmlElements = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "address": true, "area": true,
+	"b": true, "bdo": true, "big": true, "blockquote": true, "br": true,
+	"caption": true, "center": true, "cite": true, "code": true, "col": true,
+	"colgroup": true, "dd": true, "del": true, "dfn": true, "div": true,
+	"dl": true, "dt": true, "em": true, "font": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "hr": true, "i": true,
+	"img": true, "ins": true, "kbd": true, "li": true, "map": true, "ol": true,
+	"p": true, "pre": true, "q": true, "s": true, "samp": true, "small": true,
+	"span": true, "strike": true, "strong": true, "sub": true, "sup": true,
+	"table": true, "tbody": true, "td": true, "tfoot": true, "th": true,
+	"thead": true, "title": true, "tr": true, "tt": true, "u": true, "ul": true,
+	"var": true, "xmp": true,
+}
+
+var voidElements = map[string]bool{"area": true, "br": true, "col": true, "hr": true, "img": true}
+
+type node struct {
+	name     string // "" for text
+	attrs    []xml.Attr
+	text     string
+	children []*node
+}
+
+func (n *node) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// hasStyle tells whether the style attribute of n sets the property, as in
+// "--en-codeblock:true".
+func (n *node) hasStyle(property string) bool {
+	for _, decl := range strings.Split(n.attr("style"), ";") {
+		if strings.Replace(strings.TrimSpace(decl), " ", "", -1) == property {
+			return true
+		}
+	}
+	return false
+}
+
+func parseENML(content string) (*node, error) {
+	d := xml.NewDecoder(strings.NewReader(content))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	root := &node{}
+	stack := []*node{root}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: strings.ToLower(t.Name.Local), attrs: t.Attr}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, &node{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+func (n *node) find(name string) *node {
+	if n.name == name {
+		return n
+	}
+	for _, c := range n.children {
+		if res := c.find(name); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+func (n *node) walk(fn func(*node)) {
+	fn(n)
+	for _, c := range n.children {
+		c.walk(fn)
+	}
+}
+
+func mediaOf(n *node) Media {
+	m := Media{Hash: n.attr("hash"), Type: n.attr("type"), Attrs: make(map[string]string)}
+	for _, a := range n.attrs {
+		m.Attrs[a.Name.Local] = a.Value
+	}
+	return m
+}
+
+// MediaOf returns the en-media elements of a note in the order they
+// appear.
+func MediaOf(content string) ([]Media, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return nil, err
+	}
+	var res []Media
+	root.walk(func(n *node) {
+		if n.name == "en-media" {
+			res = append(res, mediaOf(n))
+		}
+	})
+	return res, nil
+}
+
+// Convert turns the ENML of a note into an HTML fragment: the note becomes a
+// div keeping its style, media are rendered by media, to-dos become
+// checkboxes, encrypted text a placeholder and code blocks highlighted pre
+// elements.
+// Elements ENML doesn't know are left out, keeping their content, and
+// returned by name.
+func Convert(content string, media func(Media) string) (string, []string, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return "", nil, err
+	}
+	note := root.find("en-note")
+	if note == nil {
+		return "", nil, fmt.Errorf("no en-note element")
+	}
+	c := &converter{media: media, unknown: make(map[string]bool)}
+	c.buf.WriteString(`<div class="note"`)
+	if style := note.attr("style"); style != "" {
+		fmt.Fprintf(&c.buf, ` style="%s"`, html.EscapeString(style))
+	}
+	c.buf.WriteString(">")
+	c.children(note, false)
+	c.buf.WriteString("</div>")
+	var unknown []string
+	for name := range c.unknown {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return c.buf.String(), unknown, nil
+}
+
+type converter struct {
+	buf     bytes.Buffer
+	media   func(Media) string
+	unknown map[string]bool
+}
+
+func (c *converter) children(n *node, todo bool) {
+	for _, child := range n.children {
+		c.node(child, todo)
+	}
+}
+
+// node writes n; todo tells whether n is in a to-do list.
+func (c *converter) node(n *node, todo bool) {
+	switch {
+	case n.name == "":
+		c.buf.WriteString(html.EscapeString(n.text))
+	case n.name == "en-media":
+		c.buf.WriteString(c.media(mediaOf(n)))
+	case n.name == "en-todo":
+		c.checkbox(n.attr("checked") == "true")
+	case n.name == "en-crypt":
+		c.buf.WriteString(`<span class="encrypted">[encrypted]</span>`)
+	case n.name == "div" && (n.hasStyle("-en-codeblock:true") || n.hasStyle("--en-codeblock:true")):
+		lang, code := CodeLanguage(codeText(n))
+		c.buf.WriteString(Highlight(code, lang))
+	case n.name == "ul" && n.hasStyle("--en-todo:true"):
+		c.buf.WriteString(`<ul class="todo">`)
+		c.children(n, true)
+		c.buf.WriteString("</ul>")
+	case n.name == "li" && todo:
+		c.buf.WriteString("<li>")
+		c.checkbox(n.hasStyle("--en-checked:true"))
+		c.children(n, false)
+		c.buf.WriteString("</li>")
+	case enmlElements[n.name]:
+		c.buf.WriteString("<" + n.name)
+		for _, a := range n.attrs {
+			if safeAttr(a) {
+				fmt.Fprintf(&c.buf, ` %s="%s"`, a.Name.Local, html.EscapeString(a.Value))
+			}
+		}
+		if voidElements[n.name] {
+			c.buf.WriteString("/>")
+			return
+		}
+		c.buf.WriteString(">")
+		c.children(n, false)
+		c.buf.WriteString("</" + n.name + ">")
+	default:
+		c.unknown[n.name] = true
+		c.children(n, false)
+	}
+}
+
+func (c *converter) checkbox(checked bool) {
+	if checked {
+		c.buf.WriteString(`<input type="checkbox" checked disabled/>`)
+	} else {
+		c.buf.WriteString(`<input type="checkbox" disabled/>`)
+	}
+}
+
+// safeAttr drops the attributes ENML forbids which could run scripts.
+func safeAttr(a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	if strings.HasPrefix(name, "on") || a.Name.Space != "" {
+		return false
+	}
+	if name == "href" || name == "src" {
+		return !strings.HasPrefix(strings.ToLower(strings.TrimSpace(a.Value)), "javascript:")
+	}
+	return true
+}
+
+// codeText returns the text of a code block, one line per div or br.
+func codeText(n *node) string {
+	return lines(n, nil)
+}
+
+// lines returns the text of the children of n, one line per block element
+// or br. Elements for which replace returns true are written as the text
+// it returns instead of their content.
+func lines(n *node, replace func(*node) (string, bool)) string {
+	var buf bytes.Buffer
+	var walk func(n *node)
+	walk = func(n *node) {
+		if replace != nil && n.name != "" {
+			if text, ok := replace(n); ok {
+				buf.WriteString(text)
+				return
+			}
+		}
+		switch n.name {
+		case "":
+			buf.WriteString(n.text)
+		case "br":
+			buf.WriteByte('\n')
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+		if blockElements[n.name] && n.name != "br" {
+			if b := buf.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+		}
+	}
+	for _, c := range n.children {
+		walk(c)
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+
+<en-note style="word-wrap: break-word;"><div>here is a image&nbsp;<en-media hash="f5dc" type="image/png"/> and <b onclick="x()">more</b></div>` +
+		`<div><en-todo checked="true"/>done<br/><en-todo/>to do</div>` +
+		`<ul style="--en-todo:true;"><li style="--en-checked:true;">milk</li><li style="--en-checked:false;">eggs</li></ul>` +
+		`<en-crypt hint="the usual" cipher="AES">RU5DMI1mn==</en-crypt>` +
+		`<div style="box-sizing: border-box; -en-codeblock: true;"><div>if a &lt; b {</div><div><br/></div><div>}</div></div>` +
+		`<blink>old</blink><a href="javascript:alert(1)">link</a></en-note>
+`
+	var media []Media
+	res, unknown, err := Convert(content, func(m Media) string {
+		media = append(media, m)
+		return `<img class="` + m.Hash + `"/>`
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<div class="note" style="word-wrap: break-word;">` +
+		`<div>here is a image` + "\u00a0" + `<img class="f5dc"/> and <b>more</b></div>` +
+		`<div><input type="checkbox" checked disabled/>done<br/><input type="checkbox" disabled/>to do</div>` +
+		`<ul class="todo"><li><input type="checkbox" checked disabled/>milk</li><li><input type="checkbox" disabled/>eggs</li></ul>` +
+		`<span class="encrypted">[encrypted]</span>` +
+		`<pre class="chroma"><code>if a &lt; b {` + "\n\n" + `}</code></pre>` +
+		`old<a>link</a></div>`
+	if res != want {
+		t.Errorf("Convert() = %q, want %q", res, want)
+	}
+	if !reflect.DeepEqual(unknown, []string{"blink"}) {
+		t.Errorf("got unknown elements %v, want blink", unknown)
+	}
+	if len(media) != 1 || media[0].Type != "image/png" || !media[0].IsImage() {
+		t.Errorf("got media %v", media)
+	}
+}
+
+func TestMediaOf(t *testing.T) {
+	media, err := MediaOf(`<en-note><en-media type="application/pdf" hash="aa"/><div><en-media hash="bb" type="image/jpeg" alt="cat"></en-media></div></en-note>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 2 || media[0].Hash != "aa" || media[1].Attrs["alt"] != "cat" {
+		t.Errorf("MediaOf() = %v", media)
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+const frontMatterFence = "---"
+
+// FrontMatter splits the front matter off an ENML note: "key: value" lines
+// between two "---" lines at the very top of the note, one per paragraph.
+// It returns the fields, with lower case keys, and the note without them,
+// its en-note element keeping its attributes. Notes without front matter
+// are returned unchanged.
+func FrontMatter(content string) (map[string]string, string, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return nil, "", err
+	}
+	note := root.find("en-note")
+	if note == nil {
+		return nil, content, nil
+	}
+	lines := 0
+	fields := make(map[string]string)
+	opened, closed := false, false
+	for _, n := range note.children {
+		if closed {
+			break
+		}
+		lines++
+		text := strings.TrimSpace(textOf(n))
+		switch {
+		case n.name == "" && text == "":
+			// whitespace between the paragraphs
+		case !opened && text != frontMatterFence:
+			return nil, content, nil
+		case !opened:
+			opened = true
+		case text == frontMatterFence:
+			closed = true
+		default:
+			i := strings.Index(text, ":")
+			if i < 0 {
+				return nil, content, nil
+			}
+			fields[strings.ToLower(strings.TrimSpace(text[:i]))] = strings.TrimSpace(text[i+1:])
+		}
+	}
+	if !closed {
+		return nil, content, nil
+	}
+	note.children = note.children[lines:]
+	var buf bytes.Buffer
+	note.writeXML(&buf)
+	return fields, buf.String(), nil
+}
+
+// textOf returns the text of n, which may be a text node itself.
+func textOf(n *node) string {
+	if n.name == "" {
+		return n.text
+	}
+	return lines(n, nil)
+}
+
+// writeXML writes n back as ENML, elements without content closed as
+// they open.
+func (n *node) writeXML(buf *bytes.Buffer) {
+	if n.name == "" {
+		buf.WriteString(html.EscapeString(n.text))
+		return
+	}
+	buf.WriteString("<" + n.name)
+	for _, a := range n.attrs {
+		name := a.Name.Local
+		if a.Name.Space != "" {
+			name = a.Name.Space + ":" + name
+		}
+		buf.WriteString(" " + name + `="` + html.EscapeString(a.Value) + `"`)
+	}
+	if len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, c := range n.children {
+		c.writeXML(buf)
+	}
+	buf.WriteString("</" + n.name + ">")
+}
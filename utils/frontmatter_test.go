@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestFrontMatter(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>---</div><div>Publish: 2018-08-10 08:00</div><div>expires: 2018-12-31</div><div>---</div><div>the post</div></en-note>`
+	fields, res, err := FrontMatter(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["publish"] != "2018-08-10 08:00" || fields["expires"] != "2018-12-31" || len(fields) != 2 {
+		t.Errorf("got fields %v", fields)
+	}
+	if want := "<en-note><div>the post</div></en-note>"; res != want {
+		t.Errorf("got note %q, want %q", res, want)
+	}
+
+	// the en-note element keeps its style, elements closed as they open
+	// keep the text after them
+	fields, res, err = FrontMatter(`<en-note style="word-wrap: break-word;"><div>---</div><div>slug: shop</div><div>---</div>` +
+		`<div><en-todo checked="true"/>buy milk</div><div>see <en-media hash="f5dc" type="image/png"/> this picture</div></en-note>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<en-note style="word-wrap: break-word;"><div><en-todo checked="true"/>buy milk</div><div>see <en-media hash="f5dc" type="image/png"/> this picture</div></en-note>`; res != want || fields["slug"] != "shop" {
+		t.Errorf("got %v, note %q, want %q", fields, res, want)
+	}
+
+	// paragraphs on lines of their own
+	fields, res, err = FrontMatter("<en-note>\n<div>---</div><div>publish: 2020-01-01</div><div>---</div>\n<div>the post</div></en-note>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<en-note>\n<div>the post</div></en-note>"; res != want || fields["publish"] != "2020-01-01" || len(fields) != 1 {
+		t.Errorf("got %v, note %q, want %q", fields, res, want)
+	}
+
+	for _, content := range []string{
+		`<en-note><div>first post</div></en-note>`,
+		`<en-note><div>---</div><div>publish: 2018-08-10</div></en-note>`,
+		`<en-note><div>---</div><div>not a field</div><div>---</div></en-note>`,
+		"<en-note>\n<div>Note: read this first</div>\n<div>---</div>\n<div>body</div></en-note>",
+	} {
+		fields, res, err := FrontMatter(content)
+		if err != nil || fields != nil || res != content {
+			t.Errorf("FrontMatter(%q) = %v, %q, %v, want it unchanged", content, fields, res, err)
+		}
+	}
+}
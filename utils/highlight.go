@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// languageHint matches a first line of a code block naming its language,
+// as in "lang: go".
+var languageHint = regexp.MustCompile(`(?i)^\s*lang(?:uage)?\s*:\s*([\w+#.-]+)\s*$`)
+
+// codeFormatter writes the tokens of code as spans of chroma's classes,
+// leaving their colours to the style sheet of HighlightCSS.
+var codeFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(true))
+
+// CodeLanguage splits a language hint off the first line of a code block.
+// It returns the language and the code without the hint, or "" and the
+// code unchanged when its first line isn't a hint of a language the
+// highlighter knows.
+func CodeLanguage(code string) (string, string) {
+	lines := strings.SplitN(code, "\n", 2)
+	m := languageHint.FindStringSubmatch(lines[0])
+	if m == nil || lexers.Get(m[1]) == nil {
+		return "", code
+	}
+	if len(lines) == 1 {
+		return m[1], ""
+	}
+	return m[1], lines[1]
+}
+
+// Highlight renders a code block as a pre element of class chroma whose
+// code element is classed after its language, as in language-go, and its
+// tokens as spans of chroma's classes. The language is lang when the
+// highlighter knows it and guessed from the code otherwise; code in no
+// language it knows is only escaped.
+func Highlight(code, lang string) string {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = guessLexer(code)
+	}
+	if lexer != nil && lexer.Config().Name != "plaintext" {
+		config := lexer.Config()
+		name := strings.ToLower(config.Name)
+		if len(config.Aliases) > 0 {
+			name = config.Aliases[0]
+		}
+		if tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code); err == nil {
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, `<pre class="chroma"><code class="language-%s">`, html.EscapeString(name))
+			if err := codeFormatter.Format(&buf, styles.Fallback, tokens); err == nil {
+				buf.WriteString("</code></pre>")
+				return buf.String()
+			}
+		}
+	}
+	return `<pre class="chroma"><code>` + html.EscapeString(code) + "</code></pre>"
+}
+
+// guessLexer returns the lexer of the interpreter a script names on its
+// first line, as in "#!/usr/bin/env python3", or else the one the code
+// looks most like, if any.
+func guessLexer(code string) chroma.Lexer {
+	if strings.HasPrefix(code, "#!") {
+		fields := strings.Fields(strings.SplitN(code[2:], "\n", 2)[0])
+		if len(fields) > 1 && path.Base(fields[0]) == "env" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			if lexer := lexers.Get(strings.TrimRight(path.Base(fields[0]), "0123456789.")); lexer != nil {
+				return lexer
+			}
+		}
+	}
+	return lexers.Analyse(code)
+}
+
+// HighlightCSS returns the style sheet colouring highlighted code in the
+// named chroma style, such as "github" or "monokai".
+func HighlightCSS(style string) (string, error) {
+	s, ok := styles.Registry[strings.ToLower(style)]
+	if !ok {
+		return "", fmt.Errorf("unknown highlight style %q", style)
+	}
+	var buf bytes.Buffer
+	if err := codeFormatter.WriteCSS(&buf, s); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
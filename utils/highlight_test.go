@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeLanguage(t *testing.T) {
+	for code, want := range map[string][2]string{
+		"lang: go\nx := 1":      {"go", "x := 1"},
+		"Language: Python":      {"Python", ""},
+		"lang: klingon\nqapla'": {"", "lang: klingon\nqapla'"},
+		"x := 1":                {"", "x := 1"},
+	} {
+		if lang, rest := CodeLanguage(code); lang != want[0] || rest != want[1] {
+			t.Errorf("CodeLanguage(%q) = %q, %q, want %q, %q", code, lang, rest, want[0], want[1])
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, c := range []struct{ code, lang, want string }{
+		{"x := <-c", "golang", `<pre class="chroma"><code class="language-go"><span class="nx">x</span> <span class="o">:=</span> <span class="o">&lt;-</span><span class="nx">c</span></code></pre>`},
+		{"package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}", "", `<code class="language-go"><span class="kn">package</span>`},
+		{"#!/usr/bin/env python3\nprint(1)", "", `<code class="language-python">`},
+		{"a < b", "", `<pre class="chroma"><code>a &lt; b</code></pre>`},
+	} {
+		if got := Highlight(c.code, c.lang); !strings.Contains(got, c.want) {
+			t.Errorf("Highlight(%q, %q) = %s, want %s", c.code, c.lang, got, c.want)
+		}
+	}
+}
+
+func TestHighlightCSS(t *testing.T) {
+	css, err := HighlightCSS("monokai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(css, ".chroma .k {") {
+		t.Errorf("HighlightCSS() = %s", css)
+	}
+	if _, err := HighlightCSS("no such style"); err == nil {
+		t.Error("HighlightCSS() of an unknown style succeeded")
+	}
+}
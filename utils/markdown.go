@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// MarkdownMarker is the first line which marks a note written in Markdown.
+const MarkdownMarker = "<!-- markdown -->"
+
+// mediaPlaceholder stands for an en-media element in the Markdown of a
+// note until it is rendered: the object replacement character.
+const mediaPlaceholder = "\ufffc"
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(
+		html.WithXHTML(),
+		renderer.WithNodeRenderers(util.Prioritized(codeRenderer{}, 100)),
+	),
+)
+
+// codeRenderer highlights the code blocks of Markdown like those of ENML,
+// taking the language of fenced ones from their info string.
+type codeRenderer struct{}
+
+func (codeRenderer) RegisterFuncs(r renderer.NodeRendererFuncRegisterer) {
+	r.Register(ast.KindFencedCodeBlock, renderCode)
+	r.Register(ast.KindCodeBlock, renderCode)
+}
+
+func renderCode(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code.Write(seg.Value(source))
+	}
+	var lang string
+	if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+		lang = string(fenced.Language(source))
+	}
+	w.WriteString(Highlight(code.String(), lang) + "\n")
+	return ast.WalkSkipChildren, nil
+}
+
+// markdownText returns the Markdown a note is written in, as typed in its
+// lines, and its en-media elements, each of which stands at a
+// mediaPlaceholder in the text.
+func markdownText(content string) (string, []Media, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return "", nil, err
+	}
+	note := root.find("en-note")
+	if note == nil {
+		return "", nil, fmt.Errorf("no en-note element")
+	}
+	var media []Media
+	text := lines(note, func(n *node) (string, bool) {
+		switch n.name {
+		case "en-media":
+			media = append(media, mediaOf(n))
+			return mediaPlaceholder, true
+		case "en-todo":
+			if n.attr("checked") == "true" {
+				return "[x] ", true
+			}
+			return "[ ] ", true
+		case "en-crypt":
+			return "[encrypted]", true
+		}
+		return "", false
+	})
+	// Evernote keeps runs of spaces, as for indented code, as no-break
+	// spaces, which Markdown doesn't take for spaces.
+	text = strings.Replace(text, "\u00a0", " ", -1)
+	return text, media, nil
+}
+
+// cutMarker returns text without its first line when that is the
+// MarkdownMarker, and whether it was.
+func cutMarker(text string) (string, bool) {
+	lines := strings.SplitN(strings.TrimLeft(text, " \t\n"), "\n", 2)
+	if strings.TrimSpace(lines[0]) != MarkdownMarker {
+		return text, false
+	}
+	if len(lines) == 1 {
+		return "", true
+	}
+	return lines[1], true
+}
+
+// IsMarkdown tells whether the first line of a note is the MarkdownMarker.
+func IsMarkdown(content string) (bool, error) {
+	text, _, err := markdownText(content)
+	if err != nil {
+		return false, err
+	}
+	_, marked := cutMarker(text)
+	return marked, nil
+}
+
+// ConvertMarkdown renders a note written in Markdown, CommonMark with the
+// GitHub extensions, into an HTML fragment like Convert does; its media
+// are rendered by media. Raw HTML in the Markdown is left out. A leading
+// MarkdownMarker is dropped.
+func ConvertMarkdown(content string, media func(Media) string) (string, []string, error) {
+	text, found, err := markdownText(content)
+	if err != nil {
+		return "", nil, err
+	}
+	text, _ = cutMarker(text)
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(text), &buf); err != nil {
+		return "", nil, err
+	}
+	parts := strings.Split(buf.String(), mediaPlaceholder)
+	var out bytes.Buffer
+	out.WriteString(`<div class="note markdown">`)
+	for i, part := range parts {
+		if i > 0 && i-1 < len(found) {
+			out.WriteString(media(found[i-1]))
+		}
+		out.WriteString(part)
+	}
+	out.WriteString("</div>")
+	return out.String(), nil, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertMarkdown(t *testing.T) {
+	content := `<en-note><div>&lt;!-- markdown --&gt;</div><div># Title</div><div><br/></div>` +
+		`<div>Some *text* and <en-media hash="f5dc" type="image/png"/></div><div><br/></div>` +
+		`<div>| a | b |</div><div>|---|---|</div><div>| 1 | 2 |</div><div><br/></div>` +
+		"<div>```go</div><div>if a &lt; b {</div><div>&nbsp;&nbsp;&nbsp; return</div><div>}</div><div>```</div><div><br/></div>" +
+		`<div>- [x] done</div><div>- [ ] to do</div><div><br/></div>` +
+		`<div>&lt;script&gt;alert(1)&lt;/script&gt;</div></en-note>`
+	res, unknown, err := ConvertMarkdown(content, func(m Media) string {
+		return `<img class="` + m.Hash + `"/>`
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown != nil {
+		t.Errorf("got unknown elements %v", unknown)
+	}
+	for _, want := range []string{
+		`<div class="note markdown"><h1>Title</h1>`,
+		`<p>Some <em>text</em> and <img class="f5dc"/></p>`,
+		`<th>a</th>`,
+		`<td>2</td>`,
+		`<pre class="chroma"><code class="language-go"><span class="k">if</span> <span class="nx">a</span>`,
+		"\n    <span class=\"k\">return</span>\n",
+		`<li><input checked="" disabled="" type="checkbox" /> done</li>`,
+		`<!-- raw HTML omitted -->`,
+	} {
+		if !strings.Contains(res, want) {
+			t.Errorf("ConvertMarkdown() lacks %s:\n%s", want, res)
+		}
+	}
+	if strings.Contains(res, "markdown --") || strings.Contains(res, "<script>") {
+		t.Errorf("ConvertMarkdown() = %s", res)
+	}
+}
+
+func TestIsMarkdown(t *testing.T) {
+	for content, want := range map[string]bool{
+		`<en-note><div><br/></div><div>&lt;!-- markdown --&gt;</div><div># Title</div></en-note>`: true,
+		`<en-note><div># Title</div><div>&lt;!-- markdown --&gt;</div></en-note>`:                 false,
+		`<en-note><div>plain</div></en-note>`:                                                     false,
+	} {
+		if got, err := IsMarkdown(content); got != want || err != nil {
+			t.Errorf("IsMarkdown(%s) = %v, %v, want %v", content, got, err, want)
+		}
+	}
+}
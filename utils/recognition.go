@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+type recoIndex struct {
+	Items []struct {
+		Texts []struct {
+			Weight int    `xml:"w,attr"`
+			Text   string `xml:",chardata"`
+		} `xml:"t"`
+	} `xml:"item"`
+}
+
+// Recognition returns the text recognised in an image from its recoIndex
+// document: the alternative with the highest confidence of every item,
+// separated by spaces.
+func Recognition(doc []byte) (string, error) {
+	if len(doc) == 0 {
+		return "", nil
+	}
+	var index recoIndex
+	if err := xml.Unmarshal(doc, &index); err != nil {
+		return "", err
+	}
+	var words []string
+	for _, item := range index.Items {
+		best, weight := "", -1
+		for _, t := range item.Texts {
+			if text := strings.TrimSpace(t.Text); text != "" && t.Weight > weight {
+				best, weight = text, t.Weight
+			}
+		}
+		if best != "" {
+			words = append(words, best)
+		}
+	}
+	return strings.Join(words, " "), nil
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestRecognition(t *testing.T) {
+	reco := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE recoIndex PUBLIC "SYSTEM" "http://xml.evernote.com/pub/recoIndex.dtd">
+<recoIndex docType="picture" objType="image" objID="5eb63bbbe01eeed093cb22bb8f5acdc3" engineVersion="5.5.22.7" recoType="service" lang="en" objWidth="400" objHeight="300">
+<item x="10" y="10" w="80" h="20"><t w="31">HELL0</t><t w="87">HELLO</t></item>
+<item x="100" y="10" w="80" h="20"><t w="74">WORLD</t></item>
+<item x="10" y="50" w="20" h="20"><object type="face" w="40"/></item>
+</recoIndex>`
+	res, err := Recognition([]byte(reco))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "HELLO WORLD"; res != want {
+		t.Errorf("Recognition() = %q, want %q", res, want)
+	}
+	if res, err := Recognition(nil); res != "" || err != nil {
+		t.Errorf("Recognition(nil) = %q, %v", res, err)
+	}
+}
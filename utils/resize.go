@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Resize scales img down to width, keeping its aspect ratio. Every pixel of
+// the result is the average of the pixels of img it covers, which keeps
+// downscaled photos smooth.
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	height := (sh*width + sw/2) / sw
+	if height < 1 {
+		height = 1
+	}
+	src := rgba(img)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 == x0 {
+				x1++
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			off := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[off+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Orient turns img upright as an EXIF orientation from 2 to 8 tells, by
+// mirroring and rotating it. Other orientations leave it as it is.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src := rgba(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored, on its left side
+				sx, sy = y, x
+			case 6: // on its left side
+				sx, sy = y, h-1-x
+			case 7: // mirrored, on its right side
+				sx, sy = w-1-y, h-1-x
+			case 8: // on its right side
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// rgba returns img as RGBA pixels starting at the origin.
+func rgba(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if src, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return src
+	}
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	return src
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.NRGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	res := Resize(img, 2)
+	if b := res.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("got a %dx%d image, want 2x1", b.Dx(), b.Dy())
+	}
+	if got, want := res.RGBAAt(1, 0), (color.RGBA{128, 0, 128, 255}); got != want {
+		t.Errorf("got pixel %v, want the average %v", got, want)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2, the top left pixel red
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	red := color.RGBA{255, 0, 0, 255}
+	for orientation, want := range map[int]image.Point{
+		1: {0, 0}, 2: {2, 0}, 3: {2, 1}, 4: {0, 1},
+		5: {0, 0}, 6: {1, 0}, 7: {1, 2}, 8: {0, 2},
+	} {
+		res := Orient(img, orientation)
+		b := res.Bounds()
+		if orientation >= 5 && (b.Dx() != 2 || b.Dy() != 3) || orientation < 5 && (b.Dx() != 3 || b.Dy() != 2) {
+			t.Errorf("Orient(%d) is %dx%d", orientation, b.Dx(), b.Dy())
+			continue
+		}
+		if got := color.RGBAModel.Convert(res.At(want.X, want.Y)); got != red {
+			t.Errorf("Orient(%d) has %v at %v, want the red pixel", orientation, got, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// isCJK tells whether r is a Chinese, Japanese or Korean character.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Terms returns the distinct search terms of a text in the order they
+// first appear: lower case words of letters and digits, and the overlapping
+// bigrams of runs of Chinese, Japanese or Korean characters, as those don't
+// separate words with spaces. A run of a single such character is a term of
+// its own.
+func Terms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	var word []rune
+	var run []rune
+	flush := func() {
+		if len(word) > 0 {
+			add(strings.ToLower(string(word)))
+			word = word[:0]
+		}
+		if len(run) == 1 {
+			add(string(run))
+		}
+		for i := 0; i+1 < len(run); i++ {
+			add(string(run[i : i+2]))
+		}
+		run = run[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				flush()
+			}
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(run) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
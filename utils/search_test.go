@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	for text, want := range map[string]string{
+		"Hello, World! hello go1.8": "hello world go1 8",
+		"今天天气很好":                    "今天 天天 天气 气很 很好",
+		"用Go写博客。好":                  "用 go 写博 博客 好",
+		"Evernote 印象笔记 sync, 笔记同步":  "evernote 印象 象笔 笔记 sync 记同 同步",
+		"": "",
+	} {
+		if got := strings.Join(Terms(text), " "); got != want {
+			t.Errorf("Terms(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
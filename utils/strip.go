@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// StripMetadata removes the metadata an image carries besides its pixels,
+// such as the camera, time and GPS position of a photo, without decoding
+// it. JPEG images keep their color profile and, in a minimal EXIF segment,
+// their orientation. Other types are returned as they are.
+func StripMetadata(mime string, data []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errMalformed
+	}
+	var segments [][]byte
+	orientation := uint16(1)
+	for i := 2; ; {
+		for i+1 < len(data) && data[i] == 0xff && data[i+1] == 0xff {
+			i++ // fill bytes
+		}
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xda { // start of scan, the image data follows
+			var buf bytes.Buffer
+			buf.Write(data[:2])
+			// the orientation goes after the JFIF segment, which has to
+			// come first
+			if len(segments) > 0 && segments[0][1] == 0xe0 {
+				buf.Write(segments[0])
+				segments = segments[1:]
+			}
+			if orientation != 1 {
+				buf.Write(orientationSegment(orientation))
+			}
+			for _, segment := range segments {
+				buf.Write(segment)
+			}
+			buf.Write(data[i:])
+			return buf.Bytes(), nil
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return nil, errMalformed
+		}
+		segment := data[i : i+2+n]
+		i += 2 + n
+		switch {
+		case marker == 0xe1: // EXIF or XMP
+			if o := exifOrientation(segment[4:]); o != 0 {
+				orientation = o
+			}
+			continue
+		case marker == 0xe2 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00")):
+		case marker == 0xe0 || marker == 0xee: // JFIF, Adobe
+		case marker >= 0xe2 && marker <= 0xef, marker == 0xfe: // other applications, comments
+			continue
+		}
+		segments = append(segments, segment)
+	}
+}
+
+// Orientation returns the EXIF orientation of a JPEG image, 1 when it
+// has none: 2 to 8 when its pixels have to be mirrored or rotated to be
+// shown upright, 5 to 8 being on their side.
+func Orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xda { // start of scan, no more metadata
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		if marker == 0xe1 {
+			if o := exifOrientation(data[i+4 : i+2+n]); o >= 1 && o <= 8 {
+				return int(o)
+			}
+		}
+		i += 2 + n
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation tag of an EXIF segment, or 0.
+func exifOrientation(exif []byte) uint16 {
+	if !bytes.HasPrefix(exif, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := exif[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment returns an EXIF segment holding nothing but the
+// orientation.
+func orientationSegment(orientation uint16) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // header, first IFD at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation >> 8), byte(orientation), 0, 0, // orientation, SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngMetadata are the chunks of a PNG image which don't affect its pixels
+// but may tell about its origin.
+var pngMetadata = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errMalformed
+	}
+	var buf bytes.Buffer
+	buf.WriteString(signature)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		if !pngMetadata[string(data[i+4:i+8])] {
+			buf.Write(data[i:end])
+		}
+		i = end
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	// an EXIF segment with the orientation and a GPS position, and a
+	// comment, right after the start of image
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x02\x00" +
+		"\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00" +
+		"\x25\x88\x04\x00\x01\x00\x00\x00\x26\x00\x00\x00" +
+		"\x00\x00\x00\x00GPS 52.52N 13.40E")
+	var photo []byte
+	photo = append(photo, plain[:2]...)
+	photo = append(photo, 0xff, 0xe1, 0, byte(len(exif)+2))
+	photo = append(photo, exif...)
+	photo = append(photo, 0xff, 0xfe, 0, 9)
+	photo = append(photo, "camera!"...)
+	photo = append(photo, plain[2:]...)
+
+	res, err := StripMetadata("image/jpeg", photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(res, []byte("GPS")) || bytes.Contains(res, []byte("camera!")) {
+		t.Error("metadata left in the image")
+	}
+	if o := Orientation(res); o != 6 {
+		t.Errorf("got orientation %d, want 6", o)
+	}
+	if o := Orientation(plain); o != 1 {
+		t.Errorf("got orientation %d of an image without, want 1", o)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(res)); err != nil {
+		t.Error("stripped image doesn't decode:", err)
+	}
+	if res, err := StripMetadata("image/jpeg", plain); err != nil || !bytes.Equal(res, plain) {
+		t.Errorf("image without metadata changed, %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	// a tEXt chunk after the header; its CRC isn't checked on stripping
+	text := []byte("\x00\x00\x00\x0btEXtAuthor\x00Anna\x00\x00\x00\x00")
+	photo := append(append(append([]byte(nil), plain[:33]...), text...), plain[33:]...)
+	res, err := StripMetadata("image/png", photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, plain) {
+		t.Error("text chunk left in the image")
+	}
+	if _, err := StripMetadata("image/png", []byte("not a png")); err == nil {
+		t.Error("no error for a malformed image")
+	}
+}